@@ -0,0 +1,290 @@
+package archive
+
+import (
+	"archive/zip"
+	"container/list"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/shemanaev/inpxer/internal/filestore"
+)
+
+// ErrNotFound is returned when requested file is missing in archive.
+var ErrNotFound = errors.New("file not found in archive")
+
+// Cache keeps recently used zip archives open together with their central directory index,
+// so repeated reads from the same archive don't need to parse it again.
+// Number of simultaneously open archives is bounded, least recently used ones are closed first.
+// Cached archive is reopened when its modification time or size changes.
+type Cache struct {
+	mu      sync.Mutex
+	maxOpen int
+	lru     *list.List
+	items   map[key]*list.Element
+}
+
+// key identifies archive by store and path in it.
+type key struct {
+	store filestore.Store
+	path  string
+}
+
+// archive is an open zip file with name to entry map.
+type archive struct {
+	key     key
+	file    filestore.File
+	files   map[string]*zip.File
+	modTime time.Time
+	size    int64
+	refs    int
+	stale   bool
+}
+
+// File is a member of an archive opened for reading.
+// Stored (uncompressed) members are backed by io.SectionReader and support seeking,
+// compressed ones can only be read sequentially.
+type File struct {
+	io.Reader
+	Name    string
+	Size    int64
+	ModTime time.Time
+
+	cache   *Cache
+	archive *archive
+	content io.Closer
+}
+
+// RawFile is a member of an archive with its content left compressed,
+// so it can be copied to another archive with zip.Writer.CreateRaw.
+type RawFile struct {
+	io.Reader
+	Header zip.FileHeader
+
+	cache   *Cache
+	archive *archive
+}
+
+// NewCache creates cache holding up to maxOpen archives open.
+func NewCache(maxOpen int) *Cache {
+	if maxOpen < 1 {
+		maxOpen = 1
+	}
+
+	return &Cache{
+		maxOpen: maxOpen,
+		lru:     list.New(),
+		items:   make(map[key]*list.Element),
+	}
+}
+
+// Open returns file with given name from archive at path in store.
+// Returned file must be closed after use.
+func (c *Cache) Open(store filestore.Store, path, name string) (*File, error) {
+	a, err := c.acquire(key{store, path})
+	if err != nil {
+		return nil, err
+	}
+
+	zf, ok := a.files[name]
+	if !ok {
+		c.release(a)
+		return nil, ErrNotFound
+	}
+
+	f := &File{
+		Name:    name,
+		Size:    int64(zf.UncompressedSize64),
+		ModTime: a.modTime,
+		cache:   c,
+		archive: a,
+	}
+
+	if zf.Method == zip.Store {
+		offset, err := zf.DataOffset()
+		if err != nil {
+			c.release(a)
+			return nil, err
+		}
+		f.Reader = io.NewSectionReader(a.file, offset, f.Size)
+		return f, nil
+	}
+
+	rc, err := zf.Open()
+	if err != nil {
+		c.release(a)
+		return nil, err
+	}
+	f.Reader = rc
+	f.content = rc
+
+	return f, nil
+}
+
+// OpenRaw returns file with given name from archive at path in store without decompressing it.
+// Returned file must be closed after use.
+func (c *Cache) OpenRaw(store filestore.Store, path, name string) (*RawFile, error) {
+	a, err := c.acquire(key{store, path})
+	if err != nil {
+		return nil, err
+	}
+
+	zf, ok := a.files[name]
+	if !ok {
+		c.release(a)
+		return nil, ErrNotFound
+	}
+
+	r, err := zf.OpenRaw()
+	if err != nil {
+		c.release(a)
+		return nil, err
+	}
+
+	return &RawFile{
+		Reader:  r,
+		Header:  zf.FileHeader,
+		cache:   c,
+		archive: a,
+	}, nil
+}
+
+// Close closes all archives that are not in use.
+// Archives with open files are closed when the last file is closed.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for k, el := range c.items {
+		a := el.Value.(*archive)
+		a.stale = true
+		c.lru.Remove(el)
+		delete(c.items, k)
+		if a.refs == 0 {
+			if e := a.file.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+
+	return err
+}
+
+// Close releases file and underlying archive.
+func (f *File) Close() error {
+	var err error
+	if f.content != nil {
+		err = f.content.Close()
+	}
+	f.cache.release(f.archive)
+	return err
+}
+
+// Close releases underlying archive.
+func (f *RawFile) Close() error {
+	f.cache.release(f.archive)
+	return nil
+}
+
+// acquire returns open archive and increments its reference counter.
+func (c *Cache) acquire(k key) (*archive, error) {
+	stat, err := k.store.Stat(k.path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if el, ok := c.items[k]; ok {
+		a := el.Value.(*archive)
+		if a.modTime.Equal(stat.ModTime()) && a.size == stat.Size() {
+			a.refs++
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			return a, nil
+		}
+
+		c.remove(el)
+	}
+	c.mu.Unlock()
+
+	a, err := openArchive(k)
+	if err != nil {
+		return nil, err
+	}
+	a.refs = 1
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another request could open the same archive meanwhile, keep only one of them in cache.
+	if el, ok := c.items[k]; ok {
+		c.remove(el)
+	}
+	c.items[k] = c.lru.PushFront(a)
+	c.evict()
+
+	return a, nil
+}
+
+// release decrements reference counter of archive and closes it if it's no longer cached.
+func (c *Cache) release(a *archive) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	a.refs--
+	if a.refs == 0 && a.stale {
+		_ = a.file.Close()
+	}
+}
+
+// evict closes least recently used archives exceeding the limit.
+// Archives in use are skipped, so limit can be exceeded temporarily.
+func (c *Cache) evict() {
+	el := c.lru.Back()
+	for c.lru.Len() > c.maxOpen && el != nil {
+		prev := el.Prev()
+		if el.Value.(*archive).refs == 0 {
+			c.remove(el)
+		}
+		el = prev
+	}
+}
+
+// remove drops archive from cache and closes it if unused. Must be called with lock held.
+func (c *Cache) remove(el *list.Element) {
+	a := el.Value.(*archive)
+	c.lru.Remove(el)
+	delete(c.items, a.key)
+	a.stale = true
+	if a.refs == 0 {
+		_ = a.file.Close()
+	}
+}
+
+func openArchive(k key) (*archive, error) {
+	f, err := k.store.Open(k.path)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(f, f.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, file := range zr.File {
+		files[file.Name] = file
+	}
+
+	return &archive{
+		key:     k,
+		file:    f,
+		files:   files,
+		modTime: f.ModTime(),
+		size:    f.Size(),
+	}, nil
+}
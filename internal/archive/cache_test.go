@@ -0,0 +1,240 @@
+package archive
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/filestore"
+)
+
+// createArchive writes archive with single member book.fb2. Existing archive is replaced, not overwritten,
+// so files opened before keep reading the old one.
+func createArchive(t *testing.T, name, content string) {
+	t.Helper()
+
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("book.fb2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(content))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAll(t *testing.T, f *File) string {
+	t.Helper()
+
+	data, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+// assertClosed checks that file of archive is closed.
+func assertClosed(t *testing.T, a *archive) {
+	t.Helper()
+
+	_, err := a.file.ReadAt(make([]byte, 1), 0)
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestCacheOpen(t *testing.T) {
+	dir := t.TempDir()
+	store := filestore.Dir(dir)
+	createArchive(t, filepath.Join(dir, "a.zip"), "book a")
+
+	c := NewCache(2)
+	defer c.Close()
+
+	f, err := c.Open(store, "a.zip", "book.fb2")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "book a", readAll(t, f))
+	assert.Equal(t, int64(6), f.Size)
+	f.Close()
+
+	_, err = c.Open(store, "a.zip", "missing.fb2")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = c.Open(store, "missing.zip", "book.fb2")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Archive is opened once.
+	f2, err := c.Open(store, "a.zip", "book.fb2")
+	if assert.NoError(t, err) {
+		assert.Same(t, f.archive, f2.archive)
+		f2.Close()
+	}
+	assert.Equal(t, 1, c.lru.Len())
+}
+
+func TestCacheEvictSkipsArchivesInUse(t *testing.T) {
+	dir := t.TempDir()
+	store := filestore.Dir(dir)
+	for _, name := range []string{"a", "b", "c"} {
+		createArchive(t, filepath.Join(dir, name+".zip"), "book "+name)
+	}
+
+	c := NewCache(1)
+	defer c.Close()
+
+	a, err := c.Open(store, "a.zip", "book.fb2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Open(store, "b.zip", "book.fb2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both archives are in use, limit is exceeded.
+	assert.Equal(t, 2, c.lru.Len())
+	assert.Equal(t, "book a", readAll(t, a))
+	assert.Equal(t, "book b", readAll(t, b))
+
+	a.Close()
+	b.Close()
+
+	// Unused archives are evicted on the next open.
+	f, err := c.Open(store, "c.zip", "book.fb2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	assert.Equal(t, 1, c.lru.Len())
+	assertClosed(t, a.archive)
+	assertClosed(t, b.archive)
+}
+
+func TestCacheReopensChangedArchive(t *testing.T) {
+	dir := t.TempDir()
+	store := filestore.Dir(dir)
+	name := filepath.Join(dir, "a.zip")
+	createArchive(t, name, "old")
+
+	c := NewCache(2)
+	defer c.Close()
+
+	old, err := c.Open(store, "a.zip", "book.fb2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		content string
+		modTime time.Time
+	}{
+		{"size", "new content", time.Now()},
+		// Same size, modification time differs.
+		{"modtime", "new content", time.Now().Add(time.Hour)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			createArchive(t, name, tt.content)
+			if err := os.Chtimes(name, tt.modTime, tt.modTime); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := c.Open(store, "a.zip", "book.fb2")
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.NotSame(t, old.archive, f.archive)
+			assert.Equal(t, tt.content, readAll(t, f))
+			f.Close()
+		})
+	}
+
+	// File opened before change still reads the old archive, which is closed with it.
+	assert.Equal(t, "old", readAll(t, old))
+	assert.NoError(t, old.Close())
+	assertClosed(t, old.archive)
+	assert.Equal(t, 1, c.lru.Len())
+}
+
+func TestCacheCloseWithOpenFile(t *testing.T) {
+	dir := t.TempDir()
+	store := filestore.Dir(dir)
+	createArchive(t, filepath.Join(dir, "a.zip"), "book a")
+
+	c := NewCache(2)
+	f, err := c.Open(store, "a.zip", "book.fb2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, c.Close())
+	assert.Equal(t, 0, c.lru.Len())
+
+	// Archive stays open until the last file is closed.
+	assert.Equal(t, "book a", readAll(t, f))
+	assert.NoError(t, f.Close())
+	assertClosed(t, f.archive)
+}
+
+func TestCacheConcurrentOpen(t *testing.T) {
+	dir := t.TempDir()
+	store := filestore.Dir(dir)
+	createArchive(t, filepath.Join(dir, "a.zip"), "book a")
+
+	c := NewCache(2)
+	defer c.Close()
+
+	const n = 8
+	files := make([]*File, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			f, err := c.Open(store, "a.zip", "book.fb2")
+			if assert.NoError(t, err) {
+				assert.Equal(t, "book a", readAll(t, f))
+				files[i] = f
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	// Only one of archives opened meanwhile is kept, others are closed with their files.
+	assert.Equal(t, 1, c.lru.Len())
+	cached := c.lru.Front().Value.(*archive)
+	for _, f := range files {
+		if f != nil {
+			f.Close()
+		}
+	}
+	for _, f := range files {
+		if f != nil && f.archive != cached {
+			assertClosed(t, f.archive)
+		}
+	}
+	assert.Equal(t, 0, cached.refs)
+
+	f, err := c.Open(store, "a.zip", "book.fb2")
+	if assert.NoError(t, err) {
+		assert.Same(t, cached, f.archive)
+		f.Close()
+	}
+}
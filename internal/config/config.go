@@ -1,25 +1,97 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+
+	"github.com/shemanaev/inpxer/internal/model"
 )
 
 const configFilename = "inpxer.toml"
 
 type MyConfig struct {
-	Storage          string       `toml:"storage"`
-	Language         string       `toml:"language"`
-	Title            string       `toml:"title"`
-	AuthorNameFormat string       `toml:"author_name_format"`
-	IndexPath        string       `toml:"index_path"`
-	LibraryPath      string       `toml:"library_path"`
-	Listen           string       `toml:"listen"`
-	FullUrl          string       `toml:"full_url"`
-	Converters       []*Converter `toml:"converters"`
+	Storage          string        `toml:"storage"`
+	Language         string        `toml:"language"`
+	Title            string        `toml:"title"`
+	AuthorNameFormat string        `toml:"author_name_format"`
+	FilenameTemplate string        `toml:"filename_template"`
+	RewriteMetadata  bool          `toml:"rewrite_metadata"`
+	IndexPath        string        `toml:"index_path"`
+	LibraryPaths     Paths         `toml:"library_path"`
+	LibraryRoots     []*Root       `toml:"library_roots"`
+	Inpx             string        `toml:"inpx"`
+	InpxEncoding     string        `toml:"inpx_encoding"`
+	Listen           string        `toml:"listen"`
+	FullUrl          string        `toml:"full_url"`
+	Converters       []*Converter  `toml:"converters"`
+	ExtraFields      []*ExtraField `toml:"extra_fields"`
+	Libraries        []*Library    `toml:"libraries"`
+	S3               S3            `toml:"s3"`
+	// LibraryId is set in configuration of library from Libraries.
+	LibraryId string `toml:"-"`
+	// LibraryPath is the first folder of LibraryPaths.
+	LibraryPath string `toml:"-"`
+}
+
+// Paths is a list of folders, single folder can be set in config as a string.
+type Paths []string
+
+func (p *Paths) UnmarshalTOML(node *unstable.Node) error {
+	switch node.Kind {
+	case unstable.String:
+		*p = Paths{string(node.Data)}
+	case unstable.Array:
+		*p = nil
+		it := node.Children()
+		for it.Next() {
+			if it.Node().Kind != unstable.String {
+				return errors.New("list of folders should contain strings only")
+			}
+			*p = append(*p, string(it.Node().Data))
+		}
+	default:
+		return errors.New("folder should be a string or list of strings")
+	}
+	return nil
+}
+
+// S3 is a connection to S3-compatible object storage, used by folders set as "s3://bucket/prefix".
+type S3 struct {
+	// Endpoint is an url of storage, e.g. "http://localhost:9000" for MinIO. AWS endpoint of Region by default.
+	Endpoint string `toml:"endpoint"`
+	Region   string `toml:"region"`
+	// AccessKey and SecretKey are taken from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY when not set.
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+}
+
+// Root is a folder where files with paths relative to library matching Pattern are looked up first.
+type Root struct {
+	// Pattern is matched against path of archive or file relative to library, e.g. "fb2-1*.zip".
+	Pattern string `toml:"pattern"`
+	Path    string `toml:"path"`
+}
+
+// Library is one of collections served by single instance.
+// Settings which aren't set are taken from the top level of configuration.
+type Library struct {
+	// Id is a prefix of ids of library books, e.g. "flibusta" for "flibusta:123".
+	Id           string  `toml:"id"`
+	Title        string  `toml:"title"`
+	Inpx         string  `toml:"inpx"`
+	IndexPath    string  `toml:"index_path"`
+	LibraryPath  Paths   `toml:"library_path"`
+	LibraryRoots []*Root `toml:"library_roots"`
+	Language     string  `toml:"language"`
+	InpxEncoding string  `toml:"inpx_encoding"`
 }
 
 type Converter struct {
@@ -29,6 +101,117 @@ type Converter struct {
 	Arguments string `toml:"arguments"`
 }
 
+// ExtraField is a field of collection unknown to parser, which is indexed and shown with books.
+type ExtraField struct {
+	// Name of field in structure.info, case-insensitive.
+	Name  string `toml:"name"`
+	Title string `toml:"title"`
+}
+
+// Label returns title of field, its name if title isn't set.
+func (f *ExtraField) Label() string {
+	if f.Title != "" {
+		return f.Title
+	}
+	return f.Name
+}
+
+// ExtraFieldNames returns names of configured extra fields.
+func (c *MyConfig) ExtraFieldNames() []string {
+	names := make([]string, len(c.ExtraFields))
+	for i, f := range c.ExtraFields {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// AllLibraries returns configuration of every library. Without [[libraries]] top level
+// of configuration describes the only library.
+func (c *MyConfig) AllLibraries() []*MyConfig {
+	if len(c.Libraries) == 0 {
+		return []*MyConfig{c}
+	}
+
+	libraries := make([]*MyConfig, len(c.Libraries))
+	for i, lib := range c.Libraries {
+		libraries[i] = c.forLibrary(lib)
+	}
+	return libraries
+}
+
+// Library returns configuration of library with id. Empty id selects the only library.
+func (c *MyConfig) Library(id string) (*MyConfig, error) {
+	if id == "" {
+		if len(c.Libraries) > 1 {
+			return nil, errors.New("several libraries are configured, select one with --library")
+		}
+		return c.AllLibraries()[0], nil
+	}
+
+	for _, lib := range c.Libraries {
+		if lib.Id == id {
+			return c.forLibrary(lib), nil
+		}
+	}
+	return nil, fmt.Errorf("library not found: %s", id)
+}
+
+func (c *MyConfig) forLibrary(lib *Library) *MyConfig {
+	cfg := *c
+	cfg.Libraries = nil
+	cfg.LibraryId = lib.Id
+	cfg.Inpx = lib.Inpx
+	cfg.IndexPath = lib.IndexPath
+	cfg.Title = lib.Title
+	if cfg.Title == "" {
+		cfg.Title = lib.Id
+	}
+	if len(lib.LibraryPath) > 0 {
+		cfg.LibraryPaths = lib.LibraryPath
+		cfg.LibraryPath = lib.LibraryPath[0]
+	}
+	if len(lib.LibraryRoots) > 0 {
+		cfg.LibraryRoots = lib.LibraryRoots
+	}
+	if lib.Language != "" {
+		cfg.Language = lib.Language
+	}
+	if lib.InpxEncoding != "" {
+		cfg.InpxEncoding = lib.InpxEncoding
+	}
+	return &cfg
+}
+
+// Folders returns folders library is stored in, searched in order.
+func (c *MyConfig) Folders() []string {
+	if len(c.LibraryPaths) > 0 {
+		return c.LibraryPaths
+	}
+	return []string{c.LibraryPath}
+}
+
+func validateLibraries(libraries []*Library) error {
+	ids := make(map[string]bool)
+	indexes := make(map[string]bool)
+	for _, lib := range libraries {
+		if lib.Id == "" || strings.ContainsAny(lib.Id, model.LibraryIdSeparator+"/?#&") {
+			return fmt.Errorf("invalid library id: %q", lib.Id)
+		}
+		if ids[lib.Id] {
+			return fmt.Errorf("duplicate library id: %s", lib.Id)
+		}
+		if lib.IndexPath == "" {
+			return fmt.Errorf("index_path isn't set for library: %s", lib.Id)
+		}
+		if indexes[filepath.Clean(lib.IndexPath)] {
+			return fmt.Errorf("index_path is shared by several libraries: %s", lib.IndexPath)
+		}
+		ids[lib.Id] = true
+		indexes[filepath.Clean(lib.IndexPath)] = true
+	}
+	return nil
+}
+
 func Load() (*MyConfig, error) {
 	configFiles := []string{
 		configFilename,
@@ -55,8 +238,23 @@ func Load() (*MyConfig, error) {
 		return nil, err
 	}
 
+	return parse(data)
+}
+
+func parse(data []byte) (*MyConfig, error) {
 	var cfg MyConfig
-	if err := toml.Unmarshal(data, &cfg); err != nil {
+	if err := toml.NewDecoder(bytes.NewReader(data)).EnableUnmarshalerInterface().Decode(&cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.LibraryPaths) > 0 {
+		cfg.LibraryPath = cfg.LibraryPaths[0]
+	}
+
+	for _, f := range cfg.ExtraFields {
+		f.Name = strings.ToUpper(strings.TrimSpace(f.Name))
+	}
+
+	if err := validateLibraries(cfg.Libraries); err != nil {
 		return nil, err
 	}
 
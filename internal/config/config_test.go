@@ -0,0 +1,43 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLibraryPaths(t *testing.T) {
+	cfg, err := parse([]byte(`library_path = "/books"`))
+	assert.Nil(t, err)
+	assert.Equal(t, "/books", cfg.LibraryPath)
+	assert.Equal(t, []string{"/books"}, cfg.Folders())
+
+	cfg, err = parse([]byte(`
+library_path = ["/disk1", "/disk2"]
+
+[[library_roots]]
+pattern = "usr-*.zip"
+path = "/disk3"
+
+[[libraries]]
+id = "first"
+index_path = "/index/first"
+
+[[libraries]]
+id = "second"
+index_path = "/index/second"
+library_path = "/second"
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, "/disk1", cfg.LibraryPath)
+	assert.Equal(t, []string{"/disk1", "/disk2"}, cfg.Folders())
+	assert.Equal(t, []*Root{{Pattern: "usr-*.zip", Path: "/disk3"}}, cfg.LibraryRoots)
+
+	libraries := cfg.AllLibraries()
+	assert.Equal(t, []string{"/disk1", "/disk2"}, libraries[0].Folders())
+	assert.Equal(t, "/second", libraries[1].LibraryPath)
+	assert.Equal(t, []string{"/second"}, libraries[1].Folders())
+
+	_, err = parse([]byte(`library_path = 1`))
+	assert.NotNil(t, err)
+}
@@ -2,7 +2,8 @@ package badgerstore
 
 import (
 	"bytes"
-	"encoding/gob"
+	"errors"
+	"iter"
 
 	"github.com/dgraph-io/badger/v3"
 	"github.com/dgraph-io/badger/v3/options"
@@ -11,6 +12,12 @@ import (
 	"github.com/shemanaev/inpxer/internal/model"
 )
 
+// Key prefixes. Books written before versioned encoding was introduced are stored under bare ids.
+var (
+	bookPrefix = []byte("book/")
+	metaPrefix = []byte("meta/")
+)
+
 type Database struct {
 	storer.BookStorer
 	db *badger.DB
@@ -39,19 +46,17 @@ func (d *Database) AddBooks(books []*model.Book, partial bool) error {
 	err := d.db.Update(func(tx *badger.Txn) error {
 		for _, v := range books {
 			if partial {
-				if _, err := tx.Get([]byte(v.LibId)); err == nil {
+				if _, err := tx.Get(bookKey(v.LibId)); err == nil {
 					continue
 				}
 			}
 
-			var buf bytes.Buffer
-			enc := gob.NewEncoder(&buf)
-			err := enc.Encode(v)
+			data, err := storer.EncodeBook(v)
 			if err != nil {
 				return err
 			}
 
-			err = tx.Set([]byte(v.LibId), buf.Bytes())
+			err = tx.Set(bookKey(v.LibId), data)
 			if err != nil {
 				return err
 			}
@@ -64,27 +69,237 @@ func (d *Database) AddBooks(books []*model.Book, partial bool) error {
 }
 
 func (d *Database) GetBookById(id string) (*model.Book, error) {
-	var result model.Book
+	var result *model.Book
 	err := d.db.View(func(tx *badger.Txn) error {
-		item, err := tx.Get([]byte(id))
+		item, err := tx.Get(bookKey(id))
 		if err != nil {
 			return err
 		}
 
-		err = item.Value(func(val []byte) error {
-			buf := bytes.NewBuffer(val)
-			dev := gob.NewDecoder(buf)
+		return item.Value(func(val []byte) error {
+			result, err = storer.DecodeBook(val)
+			return err
+		})
+	})
+
+	return result, err
+}
 
-			err := dev.Decode(&result)
+func (d *Database) GetBooksByIds(ids []string) ([]*model.Book, error) {
+	var result []*model.Book
+	err := d.db.View(func(tx *badger.Txn) error {
+		for _, id := range ids {
+			item, err := tx.Get(bookKey(id))
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
 			if err != nil {
 				return err
 			}
 
+			err = item.Value(func(val []byte) error {
+				book, err := storer.DecodeBook(val)
+				if err != nil {
+					return err
+				}
+
+				result = append(result, book)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+func (d *Database) Books(after string) iter.Seq2[*model.Book, error] {
+	return func(yield func(*model.Book, error) bool) {
+		last := after
+		for {
+			var books []*model.Book
+			err := d.db.View(func(tx *badger.Txn) error {
+				opts := badger.DefaultIteratorOptions
+				opts.Prefix = bookPrefix
+				it := tx.NewIterator(opts)
+				defer it.Close()
+
+				it.Seek(bookKey(last))
+				if last != "" && it.Valid() && bytes.Equal(it.Item().Key(), bookKey(last)) {
+					it.Next()
+				}
+
+				for ; it.Valid() && len(books) < storer.IterateBatchSize; it.Next() {
+					err := it.Item().Value(func(val []byte) error {
+						book, err := storer.DecodeBook(val)
+						if err != nil {
+							return err
+						}
+
+						books = append(books, book)
+						return nil
+					})
+					if err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for _, book := range books {
+				if !yield(book, nil) {
+					return
+				}
+			}
+
+			if len(books) < storer.IterateBatchSize {
+				return
+			}
+			last = books[len(books)-1].LibId
+		}
+	}
+}
+
+func (d *Database) Count() (int, error) {
+	count := 0
+	err := d.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = bookPrefix
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			count++
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+func (d *Database) Delete(ids []string) error {
+	wb := d.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, id := range ids {
+		if err := wb.Delete(bookKey(id)); err != nil {
+			return err
+		}
+	}
+
+	return wb.Flush()
+}
+
+func (d *Database) GetMeta(key string) ([]byte, error) {
+	var result []byte
+	err := d.db.View(func(tx *badger.Txn) error {
+		item, err := tx.Get(metaKey(key))
+		if errors.Is(err, badger.ErrKeyNotFound) {
 			return nil
-		})
+		}
+		if err != nil {
+			return err
+		}
 
+		result, err = item.ValueCopy(nil)
 		return err
 	})
 
-	return &result, err
+	return result, err
+}
+
+func (d *Database) SetMeta(key string, value []byte) error {
+	return d.db.Update(func(tx *badger.Txn) error {
+		return tx.Set(metaKey(key), value)
+	})
+}
+
+func (d *Database) HasLegacyRecords() (bool, error) {
+	found := false
+	err := d.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if isLegacyKey(it.Item().Key()) {
+				found = true
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	return found, err
+}
+
+func (d *Database) MigrateLegacyRecords() error {
+	wb := d.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	err := d.db.View(func(tx *badger.Txn) error {
+		it := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if !isLegacyKey(item.Key()) {
+				continue
+			}
+
+			var data []byte
+			err := item.Value(func(val []byte) error {
+				book, err := storer.DecodeLegacyBook(val)
+				if err != nil {
+					return err
+				}
+
+				data, err = storer.EncodeBook(book)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			key := item.KeyCopy(nil)
+			if err := wb.Set(bookKey(string(key)), data); err != nil {
+				return err
+			}
+			if err := wb.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return wb.Flush()
+}
+
+func bookKey(id string) []byte {
+	return append(bookPrefix[:len(bookPrefix):len(bookPrefix)], id...)
+}
+
+func metaKey(name string) []byte {
+	return append(metaPrefix[:len(metaPrefix):len(metaPrefix)], name...)
+}
+
+func isLegacyKey(key []byte) bool {
+	return !bytes.HasPrefix(key, bookPrefix) && !bytes.HasPrefix(key, metaPrefix)
 }
@@ -0,0 +1,53 @@
+package badgerstore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/db/storer"
+	"github.com/shemanaev/inpxer/internal/db/storer/storertest"
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+func TestConformance(t *testing.T) {
+	storertest.Run(t, func(t *testing.T) storer.BookStorer {
+		db, err := Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
+
+func TestMigrateLegacyRecords(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	book := &model.Book{LibId: "42", Title: "Legacy", File: model.File{Name: "42", Ext: "fb2"}}
+	var buf bytes.Buffer
+	assert.Nil(t, gob.NewEncoder(&buf).Encode(book))
+	assert.Nil(t, db.db.Update(func(tx *badger.Txn) error {
+		return tx.Set([]byte(book.LibId), buf.Bytes())
+	}))
+
+	legacy, err := db.HasLegacyRecords()
+	assert.Nil(t, err)
+	assert.True(t, legacy)
+
+	assert.Nil(t, db.MigrateLegacyRecords())
+
+	legacy, err = db.HasLegacyRecords()
+	assert.Nil(t, err)
+	assert.False(t, legacy)
+
+	res, err := db.GetBookById("42")
+	assert.Nil(t, err)
+	assert.Equal(t, book, res)
+}
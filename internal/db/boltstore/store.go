@@ -2,7 +2,7 @@ package boltstore
 
 import (
 	"bytes"
-	"encoding/gob"
+	"iter"
 
 	bolt "go.etcd.io/bbolt"
 
@@ -10,7 +10,13 @@ import (
 	"github.com/shemanaev/inpxer/internal/model"
 )
 
-var BucketName = []byte("Books")
+var (
+	BucketName     = []byte("Books")
+	MetaBucketName = []byte("Meta")
+)
+
+// migrateBatchSize limits amount of records re-encoded in single transaction.
+const migrateBatchSize = 10000
 
 type Database struct {
 	storer.BookStorer
@@ -44,14 +50,12 @@ func (d *Database) AddBooks(books []*model.Book, partial bool) error {
 				}
 			}
 
-			var buf bytes.Buffer
-			enc := gob.NewEncoder(&buf)
-			err := enc.Encode(v)
+			data, err := storer.EncodeBook(v)
 			if err != nil {
 				return err
 			}
 
-			err = b.Put([]byte(v.LibId), buf.Bytes())
+			err = b.Put([]byte(v.LibId), data)
 			if err != nil {
 				return err
 			}
@@ -64,21 +68,237 @@ func (d *Database) AddBooks(books []*model.Book, partial bool) error {
 }
 
 func (d *Database) GetBookById(id string) (*model.Book, error) {
-	var result model.Book
+	var result *model.Book
+	err := d.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BucketName)
+		if b == nil {
+			return storer.ErrEmptyRecord
+		}
+
+		var err error
+		result, err = storer.DecodeBook(b.Get([]byte(id)))
+		return err
+	})
+
+	return result, err
+}
+
+func (d *Database) GetBooksByIds(ids []string) ([]*model.Book, error) {
+	var result []*model.Book
 	err := d.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket(BucketName)
-		v := b.Get([]byte(id))
+		if b == nil {
+			return nil
+		}
+
+		for _, id := range ids {
+			v := b.Get([]byte(id))
+			if v == nil {
+				continue
+			}
+
+			book, err := storer.DecodeBook(v)
+			if err != nil {
+				return err
+			}
+
+			result = append(result, book)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+func (d *Database) Books(after string) iter.Seq2[*model.Book, error] {
+	return func(yield func(*model.Book, error) bool) {
+		last := []byte(after)
+		for {
+			var books []*model.Book
+			err := d.db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket(BucketName)
+				if b == nil {
+					return nil
+				}
+
+				c := b.Cursor()
+				k, v := c.Seek(last)
+				if len(last) > 0 && bytes.Equal(k, last) {
+					k, v = c.Next()
+				}
+
+				for ; k != nil && len(books) < storer.IterateBatchSize; k, v = c.Next() {
+					book, err := storer.DecodeBook(v)
+					if err != nil {
+						return err
+					}
+
+					books = append(books, book)
+					last = append(last[:0], k...)
+				}
+
+				return nil
+			})
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for _, book := range books {
+				if !yield(book, nil) {
+					return
+				}
+			}
+
+			if len(books) < storer.IterateBatchSize {
+				return
+			}
+		}
+	}
+}
+
+func (d *Database) Count() (int, error) {
+	count := 0
+	err := d.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BucketName)
+		if b != nil {
+			count = b.Stats().KeyN
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+func (d *Database) Delete(ids []string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BucketName)
+		if b == nil {
+			return nil
+		}
+
+		for _, id := range ids {
+			if err := b.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
 
-		buf := bytes.NewBuffer(v)
-		dev := gob.NewDecoder(buf)
+func (d *Database) GetMeta(key string) ([]byte, error) {
+	var result []byte
+	err := d.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(MetaBucketName)
+		if b == nil {
+			return nil
+		}
 
-		err := dev.Decode(&result)
+		if v := b.Get([]byte(key)); v != nil {
+			result = append([]byte{}, v...)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+func (d *Database) SetMeta(key string, value []byte) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(MetaBucketName)
 		if err != nil {
 			return err
 		}
 
+		return b.Put([]byte(key), value)
+	})
+}
+
+// HasLegacyRecords reports whether there are books stored without index metadata.
+// Versioned and legacy records can't be mixed in single bucket, so any book will do.
+func (d *Database) HasLegacyRecords() (bool, error) {
+	found := false
+	err := d.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(MetaBucketName) != nil {
+			return nil
+		}
+
+		b := tx.Bucket(BucketName)
+		if b == nil {
+			return nil
+		}
+
+		k, _ := b.Cursor().First()
+		found = k != nil
 		return nil
 	})
 
-	return &result, err
+	return found, err
+}
+
+func (d *Database) MigrateLegacyRecords() error {
+	var last []byte
+	for {
+		done := true
+		err := d.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(BucketName)
+			if b == nil {
+				return nil
+			}
+
+			var keys, values [][]byte
+			scanned := 0
+			c := b.Cursor()
+			k, v := c.First()
+			if last != nil {
+				k, v = c.Seek(last)
+				if k != nil && string(k) == string(last) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && scanned < migrateBatchSize; k, v = c.Next() {
+				scanned++
+				last = append(last[:0], k...)
+
+				// Already migrated by interrupted run.
+				if _, err := storer.DecodeBook(v); err == nil {
+					continue
+				}
+
+				book, err := storer.DecodeLegacyBook(v)
+				if err != nil {
+					return err
+				}
+
+				data, err := storer.EncodeBook(book)
+				if err != nil {
+					return err
+				}
+
+				keys = append(keys, append([]byte{}, k...))
+				values = append(values, data)
+			}
+
+			for i := range keys {
+				if err := b.Put(keys[i], values[i]); err != nil {
+					return err
+				}
+			}
+
+			done = scanned < migrateBatchSize
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if done {
+			return nil
+		}
+	}
 }
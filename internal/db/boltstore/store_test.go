@@ -0,0 +1,69 @@
+package boltstore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/shemanaev/inpxer/internal/db/storer"
+	"github.com/shemanaev/inpxer/internal/db/storer/storertest"
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+func TestConformance(t *testing.T) {
+	storertest.Run(t, func(t *testing.T) storer.BookStorer {
+		db, err := Open(filepath.Join(t.TempDir(), "bolt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	})
+}
+
+func TestMigrateLegacyRecords(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var books []*model.Book
+	assert.Nil(t, db.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket(BucketName)
+		if err != nil {
+			return err
+		}
+
+		// Spans several batches.
+		for i := 0; i < migrateBatchSize+5; i++ {
+			book := &model.Book{LibId: fmt.Sprintf("%06d", i), Title: "Legacy", File: model.File{Name: "42", Ext: "fb2"}}
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(book); err != nil {
+				return err
+			}
+			if err := b.Put([]byte(book.LibId), buf.Bytes()); err != nil {
+				return err
+			}
+			books = append(books, book)
+		}
+
+		return nil
+	}))
+
+	legacy, err := db.HasLegacyRecords()
+	assert.Nil(t, err)
+	assert.True(t, legacy)
+
+	assert.Nil(t, db.MigrateLegacyRecords())
+	// Interrupted migration is resumed.
+	assert.Nil(t, db.MigrateLegacyRecords())
+
+	res, err := db.GetBooksByIds([]string{books[0].LibId, books[len(books)-1].LibId})
+	assert.Nil(t, err)
+	assert.Equal(t, []*model.Book{books[0], books[len(books)-1]}, res)
+}
@@ -1,8 +1,11 @@
 package db
 
 import (
+	"iter"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/shemanaev/inpxer/internal/db/badgerstore"
 	"github.com/shemanaev/inpxer/internal/db/boltstore"
@@ -16,6 +19,8 @@ const (
 	blevePath  = "bleve"
 	badgerPath = "badger"
 	boltPath   = "bolt"
+
+	modifiedMarker = "modified"
 )
 
 type SearchResult struct {
@@ -24,11 +29,20 @@ type SearchResult struct {
 }
 
 type Store struct {
-	fts fts.Indexer
-	db  storer.BookStorer
+	fts  fts.Indexer
+	db   storer.BookStorer
+	path string
+	// shared stores are kept open for the whole process.
+	shared bool
+	// extraFields are names of Book.Extra fields added to full-text index.
+	extraFields []string
 }
 
 func Open(path string, storage string) (*Store, error) {
+	if IsMemory(storage) {
+		return openMemory(path)
+	}
+
 	indexer, err := blevefts.Open(filepath.Join(path, blevePath))
 	if err != nil {
 		return nil, err
@@ -36,16 +50,33 @@ func Open(path string, storage string) (*Store, error) {
 
 	data, err := openStorage(storage, path)
 	if err != nil {
+		indexer.Close()
 		return nil, err
 	}
 
-	return &Store{
-		fts: indexer,
-		db:  data,
-	}, nil
+	store := &Store{
+		fts:  indexer,
+		db:   data,
+		path: path,
+	}
+
+	version, err := store.SchemaVersion()
+	if err == nil && version != SchemaVersion && version != schemaUnset {
+		err = schemaError(version)
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return store, nil
 }
 
 func Create(path, language string, storage string) (*Store, error) {
+	if IsMemory(storage) {
+		return createMemory(path, language)
+	}
+
 	indexer, err := blevefts.Create(filepath.Join(path, blevePath), language)
 	if err != nil {
 		return nil, err
@@ -53,13 +84,30 @@ func Create(path, language string, storage string) (*Store, error) {
 
 	data, err := openStorage(storage, path)
 	if err != nil {
+		indexer.Close()
 		return nil, err
 	}
 
-	return &Store{
-		fts: indexer,
-		db:  data,
-	}, nil
+	store := &Store{
+		fts:  indexer,
+		db:   data,
+		path: path,
+	}
+
+	version, err := store.SchemaVersion()
+	if err == nil {
+		if version == schemaUnset {
+			err = store.setSchemaVersion(SchemaVersion)
+		} else if version != SchemaVersion {
+			err = schemaError(version)
+		}
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return store, nil
 }
 
 func openStorage(storage string, path string) (storer.BookStorer, error) {
@@ -70,6 +118,7 @@ func openStorage(storage string, path string) (storer.BookStorer, error) {
 	case "bolt":
 		res, err = boltstore.Open(filepath.Join(path, boltPath))
 	case "badger":
+		fallthrough
 	default:
 		res, err = badgerstore.Open(filepath.Join(path, badgerPath))
 	}
@@ -78,6 +127,10 @@ func openStorage(storage string, path string) (storer.BookStorer, error) {
 }
 
 func (s *Store) Close() error {
+	if s.shared {
+		return nil
+	}
+
 	err := s.fts.Close()
 	if err != nil {
 		s.db.Close()
@@ -86,6 +139,35 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
+// ModTime returns the time of the last index modification.
+func (s *Store) ModTime() (time.Time, error) {
+	if s.shared {
+		return s.memoryModTime()
+	}
+
+	stat, err := os.Stat(filepath.Join(s.path, modifiedMarker))
+	if os.IsNotExist(err) {
+		// Index created by older version, use index creation time.
+		stat, err = os.Stat(filepath.Join(s.path, blevePath, "index_meta.json"))
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return stat.ModTime(), nil
+}
+
+// touch updates index modification time.
+// Files of storages can't be used for that since they are modified on every open.
+func (s *Store) touch() error {
+	now := time.Now()
+	if s.shared {
+		return s.db.SetMeta(modifiedMarker, []byte(now.UTC().Format(time.RFC3339Nano)))
+	}
+
+	return os.WriteFile(filepath.Join(s.path, modifiedMarker), []byte(now.UTC().Format(time.RFC3339Nano)), 0644)
+}
+
 func (s *Store) AddBooks(books []*model.Book, partial bool) error {
 	err := s.db.AddBooks(books, partial)
 	if err != nil {
@@ -94,14 +176,20 @@ func (s *Store) AddBooks(books []*model.Book, partial bool) error {
 
 	var ftsBooks []*fts.Book
 	for _, book := range books {
-		ftsBooks = append(ftsBooks, ftsBookFromModel(book))
+		ftsBooks = append(ftsBooks, ftsBookFromModel(book, s.extraFields))
 	}
 	err = s.fts.AddBooks(ftsBooks, partial)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return s.touch()
+}
+
+// CountExisting returns how many of books with ids are stored.
+func (s *Store) CountExisting(ids []string) (int, error) {
+	books, err := s.db.GetBooksByIds(ids)
+	return len(books), err
 }
 
 func (s *Store) GetBookById(id string) (*model.Book, error) {
@@ -109,17 +197,19 @@ func (s *Store) GetBookById(id string) (*model.Book, error) {
 }
 
 func (s *Store) SearchByField(field, query string, page, pageSize int) (*SearchResult, error) {
-	search, err := s.fts.SearchByField(field, query, page, pageSize)
+	return s.Search(field, query, page*pageSize, pageSize)
+}
+
+// Search returns up to size books starting from hit number from.
+func (s *Store) Search(field, query string, from, size int) (*SearchResult, error) {
+	search, err := s.fts.Search(field, query, from, size)
 	if err != nil {
 		return nil, err
 	}
 
-	var books []*model.Book
-	for _, id := range search.Hits {
-		book, err := s.db.GetBookById(id)
-		if err == nil {
-			books = append(books, book)
-		}
+	books, err := s.db.GetBooksByIds(search.Hits)
+	if err != nil {
+		return nil, err
 	}
 
 	return &SearchResult{
@@ -134,23 +224,53 @@ func (s *Store) GetMostRecentBooks(count int) ([]*model.Book, error) {
 		return nil, err
 	}
 
-	var books []*model.Book
-	for _, id := range search {
-		book, err := s.db.GetBookById(id)
-		if err == nil {
-			books = append(books, book)
-		}
+	return s.db.GetBooksByIds(search)
+}
+
+// Books iterates over all books ordered by id, starting right after given id.
+func (s *Store) Books(after string) iter.Seq2[*model.Book, error] {
+	return s.db.Books(after)
+}
+
+func (s *Store) Count() (int, error) {
+	return s.db.Count()
+}
+
+func (s *Store) DeleteBooks(ids []string) error {
+	err := s.db.Delete(ids)
+	if err != nil {
+		return err
 	}
 
-	return books, nil
+	err = s.fts.DeleteBooks(ids)
+	if err != nil {
+		return err
+	}
+
+	return s.touch()
+}
+
+// IndexExtraFields sets fields of Book.Extra added to full-text index by AddBooks.
+func (s *Store) IndexExtraFields(names []string) {
+	s.extraFields = names
 }
 
-func ftsBookFromModel(book *model.Book) *fts.Book {
+func ftsBookFromModel(book *model.Book, extraFields []string) *fts.Book {
 	authors := make([]string, len(book.Authors))
 	for i, v := range book.Authors {
 		authors[i] = v.String()
 	}
 
+	var extra map[string]string
+	for _, name := range extraFields {
+		if value, ok := book.Extra[name]; ok {
+			if extra == nil {
+				extra = make(map[string]string)
+			}
+			extra[name] = value
+		}
+	}
+
 	return &fts.Book{
 		LibId:    book.LibId,
 		Title:    book.Title,
@@ -158,5 +278,6 @@ func ftsBookFromModel(book *model.Book) *fts.Book {
 		Series:   book.Series,
 		SeriesNo: book.SeriesNo,
 		PubDate:  book.PubDate,
+		Extra:    extra,
 	}
 }
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const infoKey = "info"
+
+// IndexInfo describes collection and the last import into index.
+type IndexInfo struct {
+	Name    string `json:"name"`
+	Id      int    `json:"id"`
+	Comment string `json:"comment,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	ImportedAt time.Time `json:"imported_at"`
+	// Source is a name of imported .inpx file without directory.
+	Source       string `json:"source"`
+	SourceSHA256 string `json:"source_sha256"`
+	Partial      bool   `json:"partial,omitempty"`
+
+	Processed  int `json:"processed"`
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+	Deleted    int `json:"deleted"`
+	// Malformed is an amount of skipped records which can't be parsed.
+	Malformed int `json:"malformed,omitempty"`
+}
+
+// Info returns information about collection, nil if index was imported by older version.
+func (s *Store) Info() (*IndexInfo, error) {
+	value, err := s.db.GetMeta(infoKey)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var info IndexInfo
+	if err := json.Unmarshal(value, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+func (s *Store) SetInfo(info *IndexInfo) error {
+	value, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return s.db.SetMeta(infoKey, value)
+}
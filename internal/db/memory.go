@@ -0,0 +1,81 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shemanaev/inpxer/internal/db/memstore"
+	"github.com/shemanaev/inpxer/internal/fts/blevefts"
+)
+
+const memoryStorage = "memory"
+
+var (
+	ErrNotLoaded          = errors.New("index isn't loaded into memory, import collection on server start")
+	ErrMemoryNotSupported = errors.New("operation isn't supported for memory storage")
+)
+
+// Memory stores live for the whole process and are shared by everyone opening the same path.
+var (
+	memoryMu     sync.Mutex
+	memoryStores = make(map[string]*Store)
+)
+
+// IsMemory reports whether storage keeps index in memory only.
+func IsMemory(storage string) bool {
+	return strings.EqualFold(storage, memoryStorage)
+}
+
+func openMemory(path string) (*Store, error) {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	store, ok := memoryStores[path]
+	if !ok {
+		return nil, ErrNotLoaded
+	}
+
+	return store, nil
+}
+
+func createMemory(path, language string) (*Store, error) {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	if store, ok := memoryStores[path]; ok {
+		return store, nil
+	}
+
+	indexer, err := blevefts.NewMemOnly(language)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &Store{
+		fts:    indexer,
+		db:     memstore.Open(),
+		path:   path,
+		shared: true,
+	}
+
+	if err := store.setSchemaVersion(SchemaVersion); err != nil {
+		return nil, err
+	}
+	if err := store.touch(); err != nil {
+		return nil, err
+	}
+
+	memoryStores[path] = store
+	return store, nil
+}
+
+func (s *Store) memoryModTime() (time.Time, error) {
+	value, err := s.db.GetMeta(modifiedMarker)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Parse(time.RFC3339Nano, string(value))
+}
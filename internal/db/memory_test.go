@@ -0,0 +1,38 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	_, err := Open(t.Name(), "memory")
+	assert.Equal(t, ErrNotLoaded, err)
+
+	created, err := Create(t.Name(), "en", "memory")
+	assert.Nil(t, err)
+	assert.Nil(t, created.AddBooks([]*model.Book{{LibId: "1", Title: "Memory test"}}, false))
+	assert.Nil(t, created.Close())
+
+	// Closing doesn't drop shared store.
+	opened, err := Open(t.Name(), "Memory")
+	assert.Nil(t, err)
+	defer opened.Close()
+
+	book, err := opened.GetBookById("1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Memory test", book.Title)
+
+	res, err := opened.SearchByField("Title", "memory", 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), res.Total)
+
+	_, err = opened.ModTime()
+	assert.Nil(t, err)
+
+	_, err = Migrate(t.Name(), "memory")
+	assert.Equal(t, ErrMemoryNotSupported, err)
+}
@@ -0,0 +1,184 @@
+package memstore
+
+import (
+	"errors"
+	"iter"
+	"sort"
+	"sync"
+
+	"github.com/shemanaev/inpxer/internal/db/storer"
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+var ErrNotFound = errors.New("book not found")
+
+// Database keeps encoded books in memory, so returned books are never shared with storage.
+type Database struct {
+	storer.BookStorer
+	mu    sync.RWMutex
+	books map[string][]byte
+	// ids are sorted keys of books.
+	ids  []string
+	meta map[string][]byte
+}
+
+func Open() *Database {
+	return &Database{
+		books: make(map[string][]byte),
+		meta:  make(map[string][]byte),
+	}
+}
+
+func (d *Database) Close() error {
+	return nil
+}
+
+func (d *Database) AddBooks(books []*model.Book, partial bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, v := range books {
+		_, exists := d.books[v.LibId]
+		if partial && exists {
+			continue
+		}
+
+		data, err := storer.EncodeBook(v)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			i := sort.SearchStrings(d.ids, v.LibId)
+			d.ids = append(d.ids, "")
+			copy(d.ids[i+1:], d.ids[i:])
+			d.ids[i] = v.LibId
+		}
+		d.books[v.LibId] = data
+	}
+
+	return nil
+}
+
+func (d *Database) GetBookById(id string) (*model.Book, error) {
+	d.mu.RLock()
+	data, ok := d.books[id]
+	d.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return storer.DecodeBook(data)
+}
+
+func (d *Database) GetBooksByIds(ids []string) ([]*model.Book, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var result []*model.Book
+	for _, id := range ids {
+		data, ok := d.books[id]
+		if !ok {
+			continue
+		}
+
+		book, err := storer.DecodeBook(data)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, book)
+	}
+
+	return result, nil
+}
+
+func (d *Database) Books(after string) iter.Seq2[*model.Book, error] {
+	return func(yield func(*model.Book, error) bool) {
+		last := after
+		for {
+			var books []*model.Book
+			d.mu.RLock()
+			i := sort.SearchStrings(d.ids, last)
+			if last != "" && i < len(d.ids) && d.ids[i] == last {
+				i++
+			}
+			for ; i < len(d.ids) && len(books) < storer.IterateBatchSize; i++ {
+				book, err := storer.DecodeBook(d.books[d.ids[i]])
+				if err != nil {
+					d.mu.RUnlock()
+					yield(nil, err)
+					return
+				}
+
+				books = append(books, book)
+			}
+			d.mu.RUnlock()
+
+			for _, book := range books {
+				if !yield(book, nil) {
+					return
+				}
+			}
+
+			if len(books) < storer.IterateBatchSize {
+				return
+			}
+			last = books[len(books)-1].LibId
+		}
+	}
+}
+
+func (d *Database) Count() (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return len(d.books), nil
+}
+
+func (d *Database) Delete(ids []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, id := range ids {
+		if _, ok := d.books[id]; !ok {
+			continue
+		}
+
+		delete(d.books, id)
+		i := sort.SearchStrings(d.ids, id)
+		d.ids = append(d.ids[:i], d.ids[i+1:]...)
+	}
+
+	return nil
+}
+
+func (d *Database) GetMeta(key string) ([]byte, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	value, ok := d.meta[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return append([]byte{}, value...), nil
+}
+
+func (d *Database) SetMeta(key string, value []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.meta[key] = append([]byte{}, value...)
+	return nil
+}
+
+// HasLegacyRecords always reports false, legacy records can't be loaded into memory.
+func (d *Database) HasLegacyRecords() (bool, error) {
+	return false, nil
+}
+
+func (d *Database) MigrateLegacyRecords() error {
+	return nil
+}
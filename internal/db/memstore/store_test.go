@@ -0,0 +1,14 @@
+package memstore
+
+import (
+	"testing"
+
+	"github.com/shemanaev/inpxer/internal/db/storer"
+	"github.com/shemanaev/inpxer/internal/db/storer/storertest"
+)
+
+func TestConformance(t *testing.T) {
+	storertest.Run(t, func(t *testing.T) storer.BookStorer {
+		return Open()
+	})
+}
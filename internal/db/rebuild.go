@@ -0,0 +1,113 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/shemanaev/inpxer/internal/fts"
+	"github.com/shemanaev/inpxer/internal/fts/blevefts"
+)
+
+const rebuildBatchSize = 1000
+
+// Rebuild recreates full-text index at path from stored books using current mapping.
+// New index is built next to the old one and replaces it only on success, stored books are left untouched.
+// Progress is called after every batch with amounts of indexed and stored books.
+// Fields of Book.Extra listed in extraFields are indexed as well.
+func Rebuild(path, language, storage string, extraFields []string, progress func(done, total int)) error {
+	if IsMemory(storage) {
+		return ErrMemoryNotSupported
+	}
+
+	data, err := openStorage(storage, path)
+	if err != nil {
+		return err
+	}
+
+	s := &Store{
+		db:   data,
+		path: path,
+	}
+	defer data.Close()
+
+	version, err := s.SchemaVersion()
+	if err == nil && version != SchemaVersion {
+		err = schemaError(version)
+	}
+	if err != nil {
+		return err
+	}
+
+	total, err := data.Count()
+	if err != nil {
+		return err
+	}
+
+	newPath := filepath.Join(path, blevePath+".new")
+	if err := os.RemoveAll(newPath); err != nil {
+		return err
+	}
+
+	indexer, err := blevefts.Create(newPath, language)
+	if err != nil {
+		return err
+	}
+
+	done := 0
+	books := make([]*fts.Book, 0, rebuildBatchSize)
+	flush := func() error {
+		if err := indexer.AddBooks(books, false); err != nil {
+			return err
+		}
+		done += len(books)
+		books = books[:0]
+		progress(done, total)
+		return nil
+	}
+
+	for book, err := range data.Books("") {
+		if err != nil {
+			indexer.Close()
+			return err
+		}
+
+		books = append(books, ftsBookFromModel(book, extraFields))
+		if len(books) == rebuildBatchSize {
+			if err := flush(); err != nil {
+				indexer.Close()
+				return err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		indexer.Close()
+		return err
+	}
+
+	if err := indexer.Close(); err != nil {
+		return err
+	}
+
+	// Old index is moved aside and restored if the new one can't take its place.
+	indexPath := filepath.Join(path, blevePath)
+	oldPath := filepath.Join(path, blevePath+".old")
+	if err := os.RemoveAll(oldPath); err != nil {
+		return err
+	}
+	if err := os.Rename(indexPath, oldPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(newPath, indexPath); err != nil {
+		if restoreErr := os.Rename(oldPath, indexPath); restoreErr != nil && !os.IsNotExist(restoreErr) {
+			return errors.Join(err, restoreErr)
+		}
+		return err
+	}
+	if err := os.RemoveAll(oldPath); err != nil {
+		return err
+	}
+
+	return s.touch()
+}
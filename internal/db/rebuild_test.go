@@ -0,0 +1,58 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+func TestRebuild(t *testing.T) {
+	path := t.TempDir()
+	store, err := Create(path, "en", "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	books := []*model.Book{
+		{LibId: "1", Title: "Solaris"},
+		{LibId: "2", Title: "Eden"},
+		{LibId: "3", Title: "Return from the Stars"},
+	}
+	assert.NoError(t, store.AddBooks(books, false))
+	assert.NoError(t, store.Close())
+
+	var progress []int
+	err = Rebuild(path, "en", "bolt", nil, func(done, total int) {
+		assert.Equal(t, len(books), total)
+		progress = append(progress, done)
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []int{len(books)}, progress)
+
+	// Nothing but the index is left behind.
+	for _, name := range []string{blevePath + ".new", blevePath + ".old"} {
+		_, err := os.Stat(filepath.Join(path, name))
+		assert.ErrorIs(t, err, os.ErrNotExist, name)
+	}
+
+	store, err = Open(path, "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	res, err := store.SearchByField("Title", "solaris", 0, 10)
+	if assert.NoError(t, err) && assert.Equal(t, uint64(1), res.Total) {
+		assert.Equal(t, "1", res.Hits[0].LibId)
+	}
+	count, err := store.Count()
+	assert.NoError(t, err)
+	assert.Equal(t, len(books), count)
+
+	assert.Equal(t, ErrMemoryNotSupported, Rebuild(t.Name(), "en", "memory", nil, func(int, int) {}))
+}
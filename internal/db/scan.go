@@ -0,0 +1,40 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const scanStateKey = "scan"
+
+// ScannedFile describes file imported from library folder.
+type ScannedFile struct {
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+	// Books maps keys of books stored in file to their ids.
+	Books map[string]string `json:"books"`
+}
+
+// ScanState returns files imported by previous scan keyed by path, nil if library wasn't scanned.
+func (s *Store) ScanState() (map[string]*ScannedFile, error) {
+	value, err := s.db.GetMeta(scanStateKey)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	var state map[string]*ScannedFile
+	if err := json.Unmarshal(value, &state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (s *Store) SetScanState(state map[string]*ScannedFile) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return s.db.SetMeta(scanStateKey, value)
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/shemanaev/inpxer/internal/fts/blevefts"
+)
+
+// SchemaVersion is the version of index layout written by this build.
+//
+//	0: books are gob encoded, there is no index metadata.
+//	1: books are encoded by storer.EncodeBook, schema version is stored in index metadata.
+const SchemaVersion = 1
+
+const schemaVersionKey = "schema_version"
+
+// schemaUnset is reported for empty storage without schema version.
+const schemaUnset = -1
+
+var (
+	ErrSchemaOutdated = errors.New("index was created by older version of inpxer, run `inpxer migrate` to upgrade it or reimport collection")
+	ErrSchemaTooNew   = errors.New("index was created by newer version of inpxer, upgrade inpxer or reimport collection")
+)
+
+// migrations upgrade index from version of the key to the next one.
+var migrations = map[int]func(s *Store) error{
+	0: func(s *Store) error {
+		return s.db.MigrateLegacyRecords()
+	},
+}
+
+// SchemaVersion returns version of index layout.
+func (s *Store) SchemaVersion() (int, error) {
+	value, err := s.db.GetMeta(schemaVersionKey)
+	if err != nil {
+		return 0, err
+	}
+
+	if value == nil {
+		legacy, err := s.db.HasLegacyRecords()
+		if err != nil {
+			return 0, err
+		}
+		if legacy {
+			return 0, nil
+		}
+		return schemaUnset, nil
+	}
+
+	version, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q: %w", value, err)
+	}
+
+	return version, nil
+}
+
+func (s *Store) setSchemaVersion(version int) error {
+	return s.db.SetMeta(schemaVersionKey, []byte(strconv.Itoa(version)))
+}
+
+func schemaError(version int) error {
+	if version > SchemaVersion {
+		return ErrSchemaTooNew
+	}
+	return ErrSchemaOutdated
+}
+
+// Migrate upgrades index at path to current schema version in place.
+// Returns version the index had before migration.
+func Migrate(path string, storage string) (int, error) {
+	if IsMemory(storage) {
+		return 0, ErrMemoryNotSupported
+	}
+
+	indexer, err := blevefts.Open(filepath.Join(path, blevePath))
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := openStorage(storage, path)
+	if err != nil {
+		indexer.Close()
+		return 0, err
+	}
+
+	s := &Store{
+		fts:  indexer,
+		db:   data,
+		path: path,
+	}
+	defer s.Close()
+
+	from, err := s.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	if from == schemaUnset {
+		return SchemaVersion, s.setSchemaVersion(SchemaVersion)
+	}
+
+	if from > SchemaVersion {
+		return from, ErrSchemaTooNew
+	}
+
+	for version := from; version < SchemaVersion; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return from, fmt.Errorf("index of version %d can't be migrated, reimport collection", version)
+		}
+
+		if err := migrate(s); err != nil {
+			return from, err
+		}
+
+		if err := s.setSchemaVersion(version + 1); err != nil {
+			return from, err
+		}
+	}
+
+	return from, nil
+}
@@ -1,10 +1,32 @@
 package storer
 
-import "github.com/shemanaev/inpxer/internal/model"
+import (
+	"iter"
+
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+// IterateBatchSize is an amount of books read in single transaction by Books iterator.
+const IterateBatchSize = 1000
 
 type BookStorer interface {
 	Open(path string) (*BookStorer, error)
 	Close() error
 	AddBooks(books []*model.Book, partial bool) error
 	GetBookById(id string) (*model.Book, error)
+	// GetBooksByIds returns books in order of ids, missing ones are skipped.
+	GetBooksByIds(ids []string) ([]*model.Book, error)
+	// Books iterates over all books ordered by id, starting right after given id.
+	// Books are read in batches, so storage isn't locked while caller handles them.
+	Books(after string) iter.Seq2[*model.Book, error]
+	Count() (int, error)
+	// Delete removes books with given ids, missing ones are ignored.
+	Delete(ids []string) error
+	// GetMeta returns value of index metadata record, nil if there is no such record.
+	GetMeta(key string) ([]byte, error)
+	SetMeta(key string, value []byte) error
+	// HasLegacyRecords reports whether storage contains books written before versioned encoding was introduced.
+	HasLegacyRecords() (bool, error)
+	// MigrateLegacyRecords re-encodes books written before versioned encoding was introduced.
+	MigrateLegacyRecords() error
 }
@@ -0,0 +1,148 @@
+package storer
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+// RecordVersion is the version of encoding used for newly written books.
+//
+// Every stored book is a single version byte followed by payload:
+//
+//	1: JSON object with fields of bookRecord. Missing fields are decoded as zero values
+//	   and unknown ones are ignored, so fields can be added without changing the version.
+//
+// Records written before versioning was introduced are plain gob encoded model.Book
+// without version byte, see DecodeLegacyBook.
+const RecordVersion byte = 1
+
+var ErrEmptyRecord = errors.New("empty record")
+
+type bookRecord struct {
+	LibId    string            `json:"id"`
+	Title    string            `json:"title"`
+	Authors  []authorRecord    `json:"authors,omitempty"`
+	Genres   []string          `json:"genres,omitempty"`
+	Series   string            `json:"series,omitempty"`
+	SeriesNo int               `json:"series_no,omitempty"`
+	File     fileRecord        `json:"file"`
+	PubDate  time.Time         `json:"date"`
+	Language string            `json:"lang,omitempty"`
+	Extra    map[string]string `json:"extra,omitempty"`
+	Broken   bool              `json:"broken,omitempty"`
+}
+
+type authorRecord struct {
+	LastName   string `json:"last,omitempty"`
+	FirstName  string `json:"first,omitempty"`
+	MiddleName string `json:"middle,omitempty"`
+}
+
+type fileRecord struct {
+	Name    string `json:"name"`
+	Size    int    `json:"size"`
+	Ext     string `json:"ext"`
+	Folder  string `json:"folder,omitempty"`
+	Archive string `json:"archive,omitempty"`
+}
+
+// EncodeBook encodes book using current record version.
+func EncodeBook(book *model.Book) ([]byte, error) {
+	rec := bookRecord{
+		LibId:    book.LibId,
+		Title:    book.Title,
+		Genres:   book.Genres,
+		Series:   book.Series,
+		SeriesNo: book.SeriesNo,
+		File: fileRecord{
+			Name:    book.File.Name,
+			Size:    book.File.Size,
+			Ext:     book.File.Ext,
+			Folder:  book.File.Folder,
+			Archive: book.File.Archive,
+		},
+		PubDate:  book.PubDate,
+		Language: book.Language,
+		Extra:    book.Extra,
+		Broken:   book.Broken,
+	}
+	for _, a := range book.Authors {
+		rec.Authors = append(rec.Authors, authorRecord{
+			LastName:   a.LastName,
+			FirstName:  a.FirstName,
+			MiddleName: a.MiddleName,
+		})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(RecordVersion)
+	if err := json.NewEncoder(&buf).Encode(&rec); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodeBook decodes book record of any supported version.
+func DecodeBook(data []byte) (*model.Book, error) {
+	if len(data) == 0 {
+		return nil, ErrEmptyRecord
+	}
+
+	switch data[0] {
+	case 1:
+		var rec bookRecord
+		if err := json.Unmarshal(data[1:], &rec); err != nil {
+			return nil, err
+		}
+		return rec.toModel(), nil
+	default:
+		return nil, fmt.Errorf("unsupported record version: %d", data[0])
+	}
+}
+
+// DecodeLegacyBook decodes book written before versioned encoding was introduced.
+func DecodeLegacyBook(data []byte) (*model.Book, error) {
+	var book model.Book
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&book); err != nil {
+		return nil, err
+	}
+
+	return &book, nil
+}
+
+func (r *bookRecord) toModel() *model.Book {
+	book := &model.Book{
+		LibId:    r.LibId,
+		Title:    r.Title,
+		Genres:   r.Genres,
+		Series:   r.Series,
+		SeriesNo: r.SeriesNo,
+		File: model.File{
+			Name:    r.File.Name,
+			Size:    r.File.Size,
+			Ext:     r.File.Ext,
+			Folder:  r.File.Folder,
+			Archive: r.File.Archive,
+		},
+		PubDate:  r.PubDate,
+		Language: r.Language,
+		Extra:    r.Extra,
+		Broken:   r.Broken,
+	}
+	for _, a := range r.Authors {
+		book.Authors = append(book.Authors, model.Author{
+			LastName:   a.LastName,
+			FirstName:  a.FirstName,
+			MiddleName: a.MiddleName,
+		})
+	}
+
+	return book
+}
@@ -0,0 +1,62 @@
+package storer
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+var testBook = &model.Book{
+	LibId:    "166370",
+	Title:    "Сказки",
+	Authors:  []model.Author{{LastName: "Пушкин", FirstName: "Александр", MiddleName: "Сергеевич"}},
+	Genres:   []string{"poetry", "child_tale"},
+	Series:   "Избранное",
+	SeriesNo: 2,
+	File: model.File{
+		Name:    "166370",
+		Size:    1024,
+		Ext:     "fb2",
+		Folder:  "fb2-166043-168102.zip",
+		Archive: "fb2-166043-168102",
+	},
+	PubDate:  time.Date(2010, 1, 2, 0, 0, 0, 0, time.UTC),
+	Language: "ru",
+	Extra:    map[string]string{"PUBLISHER": "Детская литература"},
+	Broken:   true,
+}
+
+func TestEncodeDecodeBook(t *testing.T) {
+	data, err := EncodeBook(testBook)
+	assert.Nil(t, err)
+	assert.Equal(t, RecordVersion, data[0])
+
+	book, err := DecodeBook(data)
+	assert.Nil(t, err)
+	assert.Equal(t, testBook, book)
+}
+
+func TestDecodeBookErrors(t *testing.T) {
+	_, err := DecodeBook(nil)
+	assert.Equal(t, ErrEmptyRecord, err)
+
+	_, err = DecodeBook([]byte{0xff, '{', '}'})
+	assert.NotNil(t, err)
+}
+
+func TestDecodeLegacyBook(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, gob.NewEncoder(&buf).Encode(testBook))
+
+	book, err := DecodeLegacyBook(buf.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, testBook, book)
+
+	_, err = DecodeBook(buf.Bytes())
+	assert.NotNil(t, err)
+}
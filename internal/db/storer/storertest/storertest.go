@@ -0,0 +1,204 @@
+// Package storertest provides conformance tests for storer.BookStorer implementations.
+package storertest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/db/storer"
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+// Run runs conformance tests against storages created by open.
+// Each test gets new empty storage, it's closed by Run.
+func Run(t *testing.T, open func(t *testing.T) storer.BookStorer) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storer.BookStorer)
+	}{
+		{"AddAndGet", testAddAndGet},
+		{"Partial", testPartial},
+		{"GetBooksByIds", testGetBooksByIds},
+		{"Books", testBooks},
+		{"BooksAfter", testBooksAfter},
+		{"BooksStop", testBooksStop},
+		{"Count", testCount},
+		{"Delete", testDelete},
+		{"Meta", testMeta},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+			tt.fn(t, s)
+		})
+	}
+}
+
+// makeBooks returns books with ids of the same length, so they are ordered the same way as numbers.
+func makeBooks(count int) []*model.Book {
+	books := make([]*model.Book, count)
+	for i := range books {
+		books[i] = &model.Book{
+			LibId:    fmt.Sprintf("%06d", i+1),
+			Title:    fmt.Sprintf("Book %d", i+1),
+			Authors:  []model.Author{{LastName: "Author", FirstName: fmt.Sprint(i)}},
+			Genres:   []string{"sf"},
+			Series:   "Series",
+			SeriesNo: i + 1,
+			File: model.File{
+				Name:   fmt.Sprintf("%d", i+1),
+				Size:   i * 10,
+				Ext:    "fb2",
+				Folder: "fb2-000001-999999.zip",
+			},
+			PubDate:  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i),
+			Language: "en",
+		}
+	}
+	return books
+}
+
+func ids(books []*model.Book) []string {
+	res := make([]string, len(books))
+	for i, b := range books {
+		res[i] = b.LibId
+	}
+	return res
+}
+
+func collect(t *testing.T, s storer.BookStorer, after string) []*model.Book {
+	var res []*model.Book
+	for book, err := range s.Books(after) {
+		if !assert.Nil(t, err) {
+			break
+		}
+		res = append(res, book)
+	}
+	return res
+}
+
+func testAddAndGet(t *testing.T, s storer.BookStorer) {
+	books := makeBooks(3)
+	assert.Nil(t, s.AddBooks(books, false))
+
+	book, err := s.GetBookById("000002")
+	assert.Nil(t, err)
+	assert.Equal(t, books[1], book)
+
+	_, err = s.GetBookById("999999")
+	assert.NotNil(t, err)
+}
+
+func testPartial(t *testing.T, s storer.BookStorer) {
+	books := makeBooks(2)
+	assert.Nil(t, s.AddBooks(books, false))
+
+	changed := makeBooks(3)
+	changed[0].Title = "Changed"
+	assert.Nil(t, s.AddBooks(changed, true))
+
+	book, err := s.GetBookById("000001")
+	assert.Nil(t, err)
+	assert.Equal(t, "Book 1", book.Title)
+
+	book, err = s.GetBookById("000003")
+	assert.Nil(t, err)
+	assert.Equal(t, changed[2], book)
+}
+
+func testGetBooksByIds(t *testing.T, s storer.BookStorer) {
+	books := makeBooks(5)
+	assert.Nil(t, s.AddBooks(books, false))
+
+	res, err := s.GetBooksByIds([]string{"000004", "missing", "000001", "000003"})
+	assert.Nil(t, err)
+	assert.Equal(t, []*model.Book{books[3], books[0], books[2]}, res)
+
+	res, err = s.GetBooksByIds(nil)
+	assert.Nil(t, err)
+	assert.Empty(t, res)
+}
+
+func testBooks(t *testing.T, s storer.BookStorer) {
+	assert.Empty(t, collect(t, s, ""))
+
+	// Spans several batches.
+	books := makeBooks(storer.IterateBatchSize*2 + 7)
+	assert.Nil(t, s.AddBooks(books, false))
+	assert.Nil(t, s.SetMeta("key", []byte("value")))
+
+	assert.Equal(t, books, collect(t, s, ""))
+}
+
+func testBooksAfter(t *testing.T, s storer.BookStorer) {
+	books := makeBooks(10)
+	assert.Nil(t, s.AddBooks(books, false))
+
+	assert.Equal(t, books[4:], collect(t, s, "000004"))
+	assert.Equal(t, books[4:], collect(t, s, "000004x"))
+	assert.Empty(t, collect(t, s, "000010"))
+}
+
+func testBooksStop(t *testing.T, s storer.BookStorer) {
+	books := makeBooks(storer.IterateBatchSize + 1)
+	assert.Nil(t, s.AddBooks(books, false))
+
+	var seen []string
+	for book, err := range s.Books("") {
+		assert.Nil(t, err)
+		seen = append(seen, book.LibId)
+		if len(seen) == 3 {
+			break
+		}
+	}
+	assert.Equal(t, ids(books[:3]), seen)
+}
+
+func testCount(t *testing.T, s storer.BookStorer) {
+	count, err := s.Count()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	assert.Nil(t, s.AddBooks(makeBooks(42), false))
+	assert.Nil(t, s.SetMeta("key", []byte("value")))
+
+	count, err = s.Count()
+	assert.Nil(t, err)
+	assert.Equal(t, 42, count)
+}
+
+func testDelete(t *testing.T, s storer.BookStorer) {
+	books := makeBooks(5)
+	assert.Nil(t, s.AddBooks(books, false))
+
+	assert.Nil(t, s.Delete([]string{"000002", "000004", "missing"}))
+
+	_, err := s.GetBookById("000002")
+	assert.NotNil(t, err)
+
+	count, err := s.Count()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+
+	assert.Equal(t, []*model.Book{books[0], books[2], books[4]}, collect(t, s, ""))
+}
+
+func testMeta(t *testing.T, s storer.BookStorer) {
+	value, err := s.GetMeta("missing")
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+
+	assert.Nil(t, s.SetMeta("key", []byte("value")))
+	value, err = s.GetMeta("key")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	legacy, err := s.HasLegacyRecords()
+	assert.Nil(t, err)
+	assert.False(t, legacy)
+}
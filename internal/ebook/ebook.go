@@ -0,0 +1,175 @@
+// Package ebook reads and rewrites metadata embedded in book files.
+package ebook
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+var errElementNotFound = errors.New("element not found")
+
+// Metadata represents book properties stored inside of book file.
+type Metadata struct {
+	Title    string
+	Authors  []model.Author
+	Genres   []string
+	Series   string
+	SeriesNo int
+	Language string
+}
+
+// NewMetadata returns metadata for book from catalog.
+func NewMetadata(book *model.Book) *Metadata {
+	return &Metadata{
+		Title:    strings.TrimSpace(book.CleanTitle()),
+		Authors:  book.Authors,
+		Genres:   book.Genres,
+		Series:   book.Series,
+		SeriesNo: book.SeriesNo,
+		Language: book.Language,
+	}
+}
+
+// element is a position of XML element in document.
+type element struct {
+	name  xml.Name
+	attr  []xml.Attr
+	start int
+	end   int
+}
+
+func (e *element) attrValue(name string) string {
+	for _, a := range e.attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// children holds direct child elements of some parent element.
+type children struct {
+	parent element
+	// contentStart is an offset right after parent start tag.
+	contentStart int
+	// contentEnd is an offset of parent end tag.
+	contentEnd int
+	items      []element
+}
+
+// findChildren locates first element with local name parent in data and returns its direct children.
+func findChildren(data []byte, parent string) (*children, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var res *children
+	depth := 0
+	for {
+		offset := int(d.InputOffset())
+		tok, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF {
+				return nil, errElementNotFound
+			}
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if res == nil {
+				if t.Name.Local == parent {
+					res = &children{
+						parent:       element{name: t.Name, attr: t.Copy().Attr, start: offset},
+						contentStart: int(d.InputOffset()),
+					}
+				}
+				continue
+			}
+
+			depth++
+			if depth == 1 {
+				res.items = append(res.items, element{name: t.Name, attr: t.Copy().Attr, start: offset})
+			}
+		case xml.EndElement:
+			if res == nil {
+				continue
+			}
+
+			if depth == 0 {
+				res.contentEnd = offset
+				res.parent.end = int(d.InputOffset())
+				return res, nil
+			}
+
+			if depth == 1 {
+				res.items[len(res.items)-1].end = int(d.InputOffset())
+			}
+			depth--
+		}
+	}
+}
+
+// edit replaces data[start:end] with text.
+type edit struct {
+	start int
+	end   int
+	text  string
+}
+
+// removal returns edit removing element e from data together with its line if it occupies the whole line.
+func removal(data []byte, e element) edit {
+	start, end := e.start, e.end
+	for start > 0 && (data[start-1] == ' ' || data[start-1] == '\t') {
+		start--
+	}
+	for end < len(data) && (data[end] == ' ' || data[end] == '\t' || data[end] == '\r') {
+		end++
+	}
+
+	if (start == 0 || data[start-1] == '\n') && end < len(data) && data[end] == '\n' {
+		return edit{start: start, end: end + 1}
+	}
+
+	return edit{start: e.start, end: e.end}
+}
+
+// applyEdits returns copy of data with edits applied. Edits must not overlap.
+func applyEdits(data []byte, edits []edit) []byte {
+	var buf bytes.Buffer
+	pos := 0
+	for _, e := range sortEdits(edits) {
+		buf.Write(data[pos:e.start])
+		buf.WriteString(e.text)
+		pos = e.end
+	}
+	buf.Write(data[pos:])
+
+	return buf.Bytes()
+}
+
+func sortEdits(edits []edit) []edit {
+	sorted := make([]edit, len(edits))
+	copy(sorted, edits)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].start != sorted[j].start {
+			return sorted[i].start < sorted[j].start
+		}
+		return sorted[i].end < sorted[j].end
+	})
+	return sorted
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,292 @@
+package ebook
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/htmlindex"
+
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+var testMeta = &Metadata{
+	Title:    "Солярис <2>",
+	Authors:  []model.Author{{FirstName: "Станислав", LastName: "Лем"}, {FirstName: "Arkady", MiddleName: "Natanovich", LastName: "Strugatsky"}},
+	Genres:   []string{"sf", "sf_social"},
+	Series:   "Космос & море",
+	SeriesNo: 2,
+	Language: "ru",
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// checkWellFormed decodes document strictly and returns namespaces of its elements by local name.
+func checkWellFormed(t *testing.T, data []byte) map[string][]string {
+	t.Helper()
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, err
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+
+	namespaces := make(map[string][]string)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return namespaces
+		}
+		if !assert.NoError(t, err) {
+			return namespaces
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			namespaces[start.Name.Local] = append(namespaces[start.Name.Local], start.Name.Space)
+		}
+	}
+}
+
+func TestRewriteFB2(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		meta *Metadata
+		want *Metadata
+		// contains are fragments expected in rewritten book.
+		contains []string
+	}{
+		{
+			name:     "replaces children",
+			file:     "full.fb2",
+			meta:     testMeta,
+			want:     testMeta,
+			contains: []string{"<book-title>nested</book-title>", "<nickname>scanner</nickname>", "<p>Text of the book.</p>"},
+		},
+		{
+			name:     "inserts missing children in schema order",
+			file:     "bare.fb2",
+			meta:     testMeta,
+			want:     testMeta,
+			contains: []string{"</author>\n<book-title>", "</book-title>\n<annotation>", "<date>1961</date>\n  <lang>ru</lang>\n<sequence"},
+		},
+		{
+			name: "keeps children without replacement",
+			file: "full.fb2",
+			meta: &Metadata{Title: "Eden"},
+			want: &Metadata{
+				Title:    "Eden",
+				Authors:  []model.Author{{FirstName: "Stanislaw", LastName: "Lem"}},
+				Genres:   []string{"prose", "adventure"},
+				Series:   "Old",
+				SeriesNo: 7,
+				Language: "pl",
+			},
+		},
+		{
+			name: "windows-1251",
+			file: "cp1251.fb2",
+			meta: &Metadata{Title: "Эдем", Series: "Космос", Authors: []model.Author{{LastName: "Lem"}}},
+			want: &Metadata{
+				Title:    "Эдем",
+				Authors:  []model.Author{{LastName: "Lem"}},
+				Genres:   []string{"sf"},
+				Series:   "Космос",
+				Language: "ru",
+			},
+			contains: []string{`<?xml version="1.0" encoding="windows-1251"?>`, "\xd2\xe5\xea\xf1\xf2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := RewriteFB2(&out, bytes.NewReader(readTestdata(t, tt.file)), tt.meta); err != nil {
+				t.Fatal(err)
+			}
+
+			checkWellFormed(t, out.Bytes())
+			for _, s := range tt.contains {
+				assert.Contains(t, out.String(), s)
+			}
+
+			got, err := ReadFB2(&out)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+
+	// Encoding of book is kept.
+	var out bytes.Buffer
+	if err := RewriteFB2(&out, bytes.NewReader(readTestdata(t, "cp1251.fb2")), testMeta); err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, utf8.Valid(out.Bytes()))
+}
+
+// newTestEPUB returns EPUB with package document opf.
+func newTestEPUB(t *testing.T, opf []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range []struct {
+		name string
+		data string
+	}{
+		{"mimetype", "application/epub+zip"},
+		{epubContainerPath, `<?xml version="1.0"?><container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">` +
+			`<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles></container>`},
+		{"OEBPS/content.opf", string(opf)},
+		{"OEBPS/text.xhtml", "<html><body><p>Text of the book.</p></body></html>"},
+	} {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f.data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRewriteEPUB(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		meta *Metadata
+		want *Metadata
+		// contains and notContains are fragments expected and not expected in rewritten package document.
+		contains    []string
+		notContains []string
+	}{
+		{
+			name:        "EPUB 2 with calibre series",
+			file:        "epub2.opf",
+			meta:        testMeta,
+			want:        testMeta,
+			contains:    []string{`<dc:identifier id="uid">`, `<meta name="cover" content="cover-image"/>`},
+			notContains: []string{"Old", "belongs-to-collection"},
+		},
+		{
+			name:     "EPUB 3 collection and its refinements are removed",
+			file:     "epub3.opf",
+			meta:     testMeta,
+			want:     testMeta,
+			contains: []string{`property="dcterms:modified"`, `<meta refines="#inpxer-series" property="group-position">2</meta>`},
+			// Refinements of replaced title and creator are removed too.
+			notContains: []string{"Old", `refines="#old"`, `refines="#t1"`, `refines="#c1"`},
+		},
+		{
+			name: "EPUB 3 series kept when not replaced",
+			file: "epub3.opf",
+			meta: &Metadata{Title: "Eden"},
+			want: &Metadata{
+				Title:    "Eden",
+				Authors:  []model.Author{{FirstName: "Old", LastName: "Author"}},
+				Series:   "Old series",
+				SeriesNo: 3,
+				Language: "en",
+			},
+			contains: []string{`refines="#old"`, `refines="#c1"`},
+		},
+		{
+			name:        "declared prefix is used without title",
+			file:        "notitle.opf",
+			meta:        testMeta,
+			want:        testMeta,
+			contains:    []string{"<purl:title>", "<purl:creator>Arkady Natanovich Strugatsky</purl:creator>"},
+			notContains: []string{"dc:", "Old"},
+		},
+		{
+			name:     "prefix is declared when missing",
+			file:     "undeclared.opf",
+			meta:     testMeta,
+			want:     testMeta,
+			contains: []string{`<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">`, `<meta name="cover" content="cover-image"/>`},
+			// Title declaring namespace for itself is replaced as well.
+			notContains: []string{"Old"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := newTestEPUB(t, readTestdata(t, tt.file))
+
+			var out bytes.Buffer
+			if err := RewriteEPUB(&out, bytes.NewReader(book), int64(len(book)), tt.meta); err != nil {
+				t.Fatal(err)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			opf, err := readZipFile(zr, "OEBPS/content.opf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			text, err := readZipFile(zr, "OEBPS/text.xhtml")
+			assert.NoError(t, err)
+			assert.Equal(t, "<html><body><p>Text of the book.</p></body></html>", string(text))
+
+			namespaces := checkWellFormed(t, opf)
+			for _, name := range []string{"title", "creator", "language", "subject"} {
+				for _, ns := range namespaces[name] {
+					assert.Equal(t, dcNamespace, ns, name)
+				}
+			}
+			for _, s := range tt.contains {
+				assert.Contains(t, string(opf), s)
+			}
+			for _, s := range tt.notContains {
+				assert.NotContains(t, string(opf), s)
+			}
+
+			got, err := ReadEPUB(bytes.NewReader(out.Bytes()), int64(out.Len()))
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestApplyEdits(t *testing.T) {
+	data := []byte("<a>\n  <b/>\n  <c>text</c>\n</a>")
+	c, err := findChildren(data, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(t, c.items, 2) {
+		return
+	}
+
+	got := applyEdits(data, []edit{
+		{start: c.contentEnd, end: c.contentEnd, text: "<d/>\n"},
+		removal(data, c.items[0]),
+		{start: c.items[1].start, end: c.items[1].end, text: "<c/>"},
+	})
+	assert.Equal(t, "<a>\n  <c/>\n<d/>\n</a>", string(got))
+
+	_, err = findChildren(data, "missing")
+	assert.ErrorIs(t, err, errElementNotFound)
+}
@@ -0,0 +1,253 @@
+package ebook
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+)
+
+const epubContainerPath = "META-INF/container.xml"
+
+const epubSeriesId = "inpxer-series"
+
+const dcNamespace = "http://purl.org/dc/elements/1.1/"
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// RewriteEPUB copies EPUB from r to w replacing package metadata.
+// All files except package document are copied without recompression.
+// Book is copied unchanged if its package document can't be parsed.
+func RewriteEPUB(w io.Writer, r io.ReaderAt, size int64, meta *Metadata) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return copyUnchanged(w, r, size)
+	}
+
+	opfPath, opf, err := readEPUBPackage(zr)
+	if err != nil {
+		return copyUnchanged(w, r, size)
+	}
+
+	opf, err = rewriteOPF(opf, meta)
+	if err != nil {
+		return copyUnchanged(w, r, size)
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range zr.File {
+		if f.Name == opfPath {
+			fh := f.FileHeader
+			fh.Method = zip.Deflate
+			fw, err := zw.CreateHeader(&fh)
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(opf); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := copyRaw(zw, f); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func copyUnchanged(w io.Writer, r io.ReaderAt, size int64) error {
+	_, err := io.Copy(w, io.NewSectionReader(r, 0, size))
+	return err
+}
+
+func copyRaw(zw *zip.Writer, f *zip.File) error {
+	fh := f.FileHeader
+	fw, err := zw.CreateRaw(&fh)
+	if err != nil {
+		return err
+	}
+
+	rr, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fw, rr)
+	return err
+}
+
+// readEPUBPackage returns path and content of EPUB package document.
+func readEPUBPackage(zr *zip.Reader) (string, []byte, error) {
+	data, err := readZipFile(zr, epubContainerPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var container epubContainer
+	if err := xml.Unmarshal(data, &container); err != nil {
+		return "", nil, err
+	}
+
+	if len(container.Rootfiles) == 0 {
+		return "", nil, errors.New("package document not found")
+	}
+
+	opfPath := path.Clean(container.Rootfiles[0].FullPath)
+	opf, err := readZipFile(zr, opfPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return opfPath, opf, nil
+}
+
+func readZipFile(zr *zip.Reader, name string) ([]byte, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// rewriteOPF replaces Dublin Core elements and series information in package metadata.
+func rewriteOPF(opf []byte, meta *Metadata) ([]byte, error) {
+	pkg, err := findChildren(opf, "package")
+	if err != nil {
+		return nil, err
+	}
+	isEPUB3 := strings.HasPrefix(pkg.parent.attrValue("version"), "3")
+
+	metadata, err := findChildren(opf, "metadata")
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefix of Dublin Core is declared on package or metadata element, it's added when missing.
+	var edits []edit
+	declared := append(slices.Clone(metadata.parent.attr), pkg.parent.attr...)
+	dc, ok := namespacePrefix(declared, dcNamespace)
+	if !ok {
+		dc = "dc"
+		for i := 1; declaresPrefix(declared, dc); i++ {
+			dc = fmt.Sprintf("dc%d", i)
+		}
+
+		tagEnd := metadata.contentStart - 1
+		if opf[tagEnd] != '>' || opf[tagEnd-1] == '/' {
+			return nil, errors.New("metadata is empty")
+		}
+		edits = append(edits, edit{start: tagEnd, end: tagEnd, text: fmt.Sprintf(` xmlns:%s="%s"`, dc, dcNamespace)})
+	}
+
+	replaced := map[string]bool{
+		"title":    meta.Title != "",
+		"creator":  len(meta.Authors) > 0,
+		"language": meta.Language != "",
+		"subject":  len(meta.Genres) > 0,
+	}
+
+	removed := make(map[int]bool)
+	removedIds := make(map[string]bool)
+	for _, item := range metadata.items {
+		remove := false
+		if replaced[item.name.Local] && (ok && item.name.Space == dc || declaresOwnNamespace(item, dcNamespace)) {
+			remove = true
+		}
+
+		if item.name.Local == "meta" && meta.Series != "" {
+			switch item.attrValue("name") {
+			case "calibre:series", "calibre:series_index":
+				remove = true
+			}
+			if item.attrValue("property") == "belongs-to-collection" {
+				remove = true
+			}
+		}
+
+		if remove {
+			if id := item.attrValue("id"); id != "" {
+				removedIds["#"+id] = true
+			}
+			removed[item.start] = true
+			edits = append(edits, removal(opf, item))
+		}
+	}
+
+	// EPUB 3 refinements of removed elements.
+	for _, item := range metadata.items {
+		if item.name.Local == "meta" && !removed[item.start] && removedIds[item.attrValue("refines")] {
+			edits = append(edits, removal(opf, item))
+		}
+	}
+
+	var sb strings.Builder
+	if meta.Title != "" {
+		fmt.Fprintf(&sb, "<%s:title>%s</%[1]s:title>\n", dc, escape(meta.Title))
+	}
+	for _, a := range meta.Authors {
+		fmt.Fprintf(&sb, "<%s:creator>%s</%[1]s:creator>\n", dc, escape(a.String()))
+	}
+	if meta.Language != "" {
+		fmt.Fprintf(&sb, "<%s:language>%s</%[1]s:language>\n", dc, escape(meta.Language))
+	}
+	for _, genre := range meta.Genres {
+		fmt.Fprintf(&sb, "<%s:subject>%s</%[1]s:subject>\n", dc, escape(genre))
+	}
+	if meta.Series != "" {
+		fmt.Fprintf(&sb, "<meta name=\"calibre:series\" content=\"%s\"/>\n", escape(meta.Series))
+		if meta.SeriesNo > 0 {
+			fmt.Fprintf(&sb, "<meta name=\"calibre:series_index\" content=\"%d\"/>\n", meta.SeriesNo)
+		}
+		if isEPUB3 {
+			fmt.Fprintf(&sb, "<meta property=\"belongs-to-collection\" id=\"%s\">%s</meta>\n", epubSeriesId, escape(meta.Series))
+			fmt.Fprintf(&sb, "<meta refines=\"#%s\" property=\"collection-type\">series</meta>\n", epubSeriesId)
+			if meta.SeriesNo > 0 {
+				fmt.Fprintf(&sb, "<meta refines=\"#%s\" property=\"group-position\">%d</meta>\n", epubSeriesId, meta.SeriesNo)
+			}
+		}
+	}
+	edits = append(edits, edit{start: metadata.contentEnd, end: metadata.contentEnd, text: sb.String()})
+
+	return applyEdits(opf, edits), nil
+}
+
+// namespacePrefix returns prefix declared for namespace by attributes.
+func namespacePrefix(attr []xml.Attr, namespace string) (string, bool) {
+	for _, a := range attr {
+		if a.Name.Space == "xmlns" && a.Value == namespace {
+			return a.Name.Local, true
+		}
+	}
+	return "", false
+}
+
+// declaresPrefix reports whether attributes declare namespace prefix.
+func declaresPrefix(attr []xml.Attr, prefix string) bool {
+	for _, a := range attr {
+		if a.Name.Space == "xmlns" && a.Name.Local == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// declaresOwnNamespace reports whether element declares namespace for itself.
+func declaresOwnNamespace(e element, namespace string) bool {
+	if e.name.Space == "" {
+		return e.attrValue("xmlns") == namespace
+	}
+	prefix, ok := namespacePrefix(e.attr, namespace)
+	return ok && prefix == e.name.Space
+}
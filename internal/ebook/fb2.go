@@ -0,0 +1,217 @@
+package ebook
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// MaxFB2HeadSize limits amount of data read in search of book description.
+const MaxFB2HeadSize = 1 << 20
+
+var (
+	fb2DescriptionEnd = []byte("</description>")
+	xmlEncodingRe     = regexp.MustCompile(`^<\?xml[^>]*encoding=["']([^"']+)["']`)
+)
+
+// fb2TitleInfoOrder is an order of title-info children defined by FictionBook 2 schema.
+var fb2TitleInfoOrder = []string{
+	"genre", "author", "book-title", "annotation", "keywords", "date", "coverpage", "lang", "src-lang", "translator", "sequence",
+}
+
+// RewriteFB2 copies FictionBook from r to w replacing title-info with metadata.
+// Only description is buffered, the rest of the book is streamed.
+// Book is copied unchanged if its description can't be parsed.
+func RewriteFB2(w io.Writer, r io.Reader, meta *Metadata) error {
+	br := bufio.NewReaderSize(r, 64<<10)
+	head, err := readFB2Head(br)
+	if err != nil {
+		return err
+	}
+
+	if rewritten, err := rewriteFB2Head(head, meta); err == nil {
+		head = rewritten
+	}
+
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, br)
+	return err
+}
+
+// readFB2Head reads data up to and including the end of description element.
+func readFB2Head(br *bufio.Reader) ([]byte, error) {
+	var head []byte
+	for len(head) < MaxFB2HeadSize {
+		chunk, err := br.ReadSlice('>')
+		head = append(head, chunk...)
+		if bytes.HasSuffix(head, fb2DescriptionEnd) {
+			break
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return head, nil
+}
+
+func rewriteFB2Head(head []byte, meta *Metadata) ([]byte, error) {
+	enc, err := fb2Encoding(head)
+	if err != nil {
+		return nil, err
+	}
+
+	text := head
+	if enc != nil {
+		text, err = enc.NewDecoder().Bytes(head)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	titleInfo, err := findChildren(text, "title-info")
+	if err != nil {
+		return nil, err
+	}
+
+	replacements := make(map[string]string)
+	if meta.Title != "" {
+		replacements["book-title"] = fmt.Sprintf("<book-title>%s</book-title>", escape(meta.Title))
+	}
+
+	if len(meta.Genres) > 0 {
+		var genres []string
+		for _, genre := range meta.Genres {
+			genres = append(genres, fmt.Sprintf("<genre>%s</genre>", escape(genre)))
+		}
+		replacements["genre"] = strings.Join(genres, "\n")
+	}
+
+	if len(meta.Authors) > 0 {
+		var authors []string
+		for _, a := range meta.Authors {
+			var sb strings.Builder
+			sb.WriteString("<author>")
+			if a.FirstName != "" {
+				fmt.Fprintf(&sb, "<first-name>%s</first-name>", escape(a.FirstName))
+			}
+			if a.MiddleName != "" {
+				fmt.Fprintf(&sb, "<middle-name>%s</middle-name>", escape(a.MiddleName))
+			}
+			fmt.Fprintf(&sb, "<last-name>%s</last-name>", escape(a.LastName))
+			sb.WriteString("</author>")
+			authors = append(authors, sb.String())
+		}
+		replacements["author"] = strings.Join(authors, "\n")
+	}
+
+	if meta.Language != "" {
+		replacements["lang"] = fmt.Sprintf("<lang>%s</lang>", escape(meta.Language))
+	}
+
+	if meta.Series != "" {
+		if meta.SeriesNo > 0 {
+			replacements["sequence"] = fmt.Sprintf(`<sequence name="%s" number="%d"/>`, escape(meta.Series), meta.SeriesNo)
+		} else {
+			replacements["sequence"] = fmt.Sprintf(`<sequence name="%s"/>`, escape(meta.Series))
+		}
+	}
+
+	var edits []edit
+	for _, name := range fb2TitleInfoOrder {
+		if replacement, ok := replacements[name]; ok {
+			edits = append(edits, replaceChildren(text, titleInfo, name, replacement, fb2TitleInfoOrder)...)
+		}
+	}
+
+	text = applyEdits(text, edits)
+	if enc != nil {
+		text, err = encoding.HTMLEscapeUnsupported(enc.NewEncoder()).Bytes(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return text, nil
+}
+
+// replaceChildren returns edits replacing all children with given name by text.
+// If there is no such children, text is inserted according to order.
+func replaceChildren(data []byte, c *children, name, text string, order []string) []edit {
+	var edits []edit
+	for _, item := range c.items {
+		if item.name.Local != name {
+			continue
+		}
+
+		if len(edits) == 0 {
+			edits = append(edits, edit{start: item.start, end: item.end, text: text})
+		} else {
+			edits = append(edits, removal(data, item))
+		}
+	}
+
+	if len(edits) > 0 {
+		return edits
+	}
+
+	pos := c.contentEnd
+	rank := indexOf(order, name)
+	for _, item := range c.items {
+		if r := indexOf(order, item.name.Local); r > rank {
+			pos = item.start
+			break
+		}
+	}
+
+	return []edit{{start: pos, end: pos, text: text + "\n"}}
+}
+
+// fb2Encoding returns encoding declared in XML header, nil for UTF-8.
+func fb2Encoding(head []byte) (encoding.Encoding, error) {
+	m := xmlEncodingRe.FindSubmatch(bytes.TrimPrefix(head, []byte("\uFEFF")))
+	if m == nil {
+		return nil, nil
+	}
+
+	enc, err := htmlindex.Get(string(m[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	if enc == unicode.UTF8 {
+		return nil, nil
+	}
+
+	if name, _ := htmlindex.Name(enc); strings.HasPrefix(name, "utf-16") {
+		return nil, fmt.Errorf("unsupported encoding: %s", name)
+	}
+
+	return enc, nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,416 @@
+package ebook
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+type fb2TitleInfo struct {
+	Genres  []string    `xml:"genre"`
+	Authors []fb2Author `xml:"author"`
+	Title   string      `xml:"book-title"`
+	Lang    string      `xml:"lang"`
+	// Sequence may be nested, only the top one is used.
+	Sequences []struct {
+		Name   string `xml:"name,attr"`
+		Number string `xml:"number,attr"`
+	} `xml:"sequence"`
+}
+
+type fb2Author struct {
+	FirstName  string `xml:"first-name"`
+	MiddleName string `xml:"middle-name"`
+	LastName   string `xml:"last-name"`
+	Nickname   string `xml:"nickname"`
+}
+
+type opfMetadata struct {
+	Titles   []string     `xml:"metadata>title"`
+	Creators []opfCreator `xml:"metadata>creator"`
+	Language []string     `xml:"metadata>language"`
+	Subjects []string     `xml:"metadata>subject"`
+	Meta     []opfMeta    `xml:"metadata>meta"`
+}
+
+type opfCreator struct {
+	Name   string `xml:",chardata"`
+	Role   string `xml:"role,attr"`
+	FileAs string `xml:"file-as,attr"`
+}
+
+type opfMeta struct {
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Property string `xml:"property,attr"`
+	Refines  string `xml:"refines,attr"`
+	Id       string `xml:"id,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// ReadFB2 returns metadata from title-info of FictionBook.
+func ReadFB2(r io.Reader) (*Metadata, error) {
+	head, err := readFB2Head(bufio.NewReaderSize(r, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := fb2Encoding(head)
+	if err != nil {
+		return nil, err
+	}
+	if enc != nil {
+		head, err = enc.NewDecoder().Bytes(head)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var info fb2TitleInfo
+	if err := decodeElement(head, "title-info", &info); err != nil {
+		return nil, err
+	}
+
+	meta := &Metadata{
+		Title:    strings.TrimSpace(info.Title),
+		Language: strings.TrimSpace(info.Lang),
+	}
+	for _, genre := range info.Genres {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			meta.Genres = append(meta.Genres, genre)
+		}
+	}
+	for _, a := range info.Authors {
+		author := model.Author{
+			LastName:   strings.TrimSpace(a.LastName),
+			FirstName:  strings.TrimSpace(a.FirstName),
+			MiddleName: strings.TrimSpace(a.MiddleName),
+		}
+		if author.LastName == "" && author.FirstName == "" {
+			author.LastName = strings.TrimSpace(a.Nickname)
+		}
+		if author.LastName != "" || author.FirstName != "" {
+			meta.Authors = append(meta.Authors, author)
+		}
+	}
+	if len(info.Sequences) > 0 {
+		meta.Series = strings.TrimSpace(info.Sequences[0].Name)
+		meta.SeriesNo, _ = strconv.Atoi(strings.TrimSpace(info.Sequences[0].Number))
+	}
+
+	return meta, nil
+}
+
+// ReadEPUB returns metadata from EPUB package document.
+func ReadEPUB(r io.ReaderAt, size int64) (*Metadata, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	_, opf, err := readEPUBPackage(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkg opfMetadata
+	if err := decodeElement(opf, "package", &pkg); err != nil {
+		return nil, err
+	}
+
+	meta := &Metadata{}
+	if len(pkg.Titles) > 0 {
+		meta.Title = strings.TrimSpace(pkg.Titles[0])
+	}
+	if len(pkg.Language) > 0 {
+		meta.Language = strings.TrimSpace(pkg.Language[0])
+	}
+	for _, subject := range pkg.Subjects {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			meta.Genres = append(meta.Genres, subject)
+		}
+	}
+	for _, c := range pkg.Creators {
+		if c.Role != "" && c.Role != "aut" {
+			continue
+		}
+		if author, ok := parseAuthorName(c.Name, c.FileAs); ok {
+			meta.Authors = append(meta.Authors, author)
+		}
+	}
+
+	// Calibre series take precedence over EPUB 3 collections.
+	collectionId := ""
+	for _, m := range pkg.Meta {
+		switch {
+		case m.Name == "calibre:series":
+			meta.Series = strings.TrimSpace(m.Content)
+		case m.Name == "calibre:series_index":
+			if v, err := strconv.ParseFloat(strings.TrimSpace(m.Content), 64); err == nil {
+				meta.SeriesNo = int(v)
+			}
+		case m.Property == "belongs-to-collection" && meta.Series == "":
+			meta.Series = strings.TrimSpace(m.Value)
+			collectionId = "#" + m.Id
+		}
+	}
+	for _, m := range pkg.Meta {
+		if collectionId != "#" && m.Refines == collectionId && m.Property == "group-position" && meta.SeriesNo == 0 {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(m.Value), 64); err == nil {
+				meta.SeriesNo = int(v)
+			}
+		}
+	}
+
+	return meta, nil
+}
+
+// comicInfo is a metadata of comic book archive.
+type comicInfo struct {
+	Title       string `xml:"Title"`
+	Series      string `xml:"Series"`
+	Number      string `xml:"Number"`
+	Writer      string `xml:"Writer"`
+	Genre       string `xml:"Genre"`
+	LanguageISO string `xml:"LanguageISO"`
+}
+
+// ReadCBZ returns metadata from ComicInfo.xml of comic book archive.
+func ReadCBZ(r io.ReaderAt, size int64) (*Metadata, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for _, f := range zr.File {
+		if !strings.EqualFold(f.Name, "ComicInfo.xml") {
+			continue
+		}
+
+		data, err = readZipFile(zr, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+	if data == nil {
+		return nil, errElementNotFound
+	}
+
+	var info comicInfo
+	if err := decodeElement(data, "ComicInfo", &info); err != nil {
+		return nil, err
+	}
+
+	meta := &Metadata{
+		Title:    strings.TrimSpace(info.Title),
+		Series:   strings.TrimSpace(info.Series),
+		Language: strings.TrimSpace(info.LanguageISO),
+	}
+	if v, err := strconv.ParseFloat(strings.TrimSpace(info.Number), 64); err == nil {
+		meta.SeriesNo = int(v)
+	}
+	for _, name := range strings.Split(info.Writer, ",") {
+		if author, ok := parseAuthorName(name, ""); ok {
+			meta.Authors = append(meta.Authors, author)
+		}
+	}
+	for _, genre := range strings.Split(info.Genre, ",") {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			meta.Genres = append(meta.Genres, genre)
+		}
+	}
+
+	return meta, nil
+}
+
+// pdfScanSize is an amount of bytes searched for document information at both ends of PDF.
+const pdfScanSize = 1 << 20
+
+// ReadPDF returns title and author from document information dictionary of PDF.
+// Only uncompressed dictionaries are found, which is the case for most files.
+func ReadPDF(r io.ReaderAt, size int64) (*Metadata, error) {
+	head := make([]byte, min(size, pdfScanSize))
+	if _, err := r.ReadAt(head, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.HasPrefix(head, []byte("%PDF-")) {
+		return nil, errors.New("not a PDF file")
+	}
+
+	// Information dictionary is usually written at the end of file.
+	data := head
+	if size > pdfScanSize {
+		tail := make([]byte, min(size-pdfScanSize, pdfScanSize))
+		if _, err := r.ReadAt(tail, size-int64(len(tail))); err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = append(tail, head...)
+	}
+
+	meta := &Metadata{Title: pdfString(data, "/Title")}
+	if author, ok := parseAuthorName(pdfString(data, "/Author"), ""); ok {
+		meta.Authors = append(meta.Authors, author)
+	}
+	if meta.Title == "" && len(meta.Authors) == 0 {
+		return nil, errElementNotFound
+	}
+
+	return meta, nil
+}
+
+// pdfString returns value of the first string entry with non-empty value.
+func pdfString(data []byte, key string) string {
+	for rest := data; ; {
+		i := bytes.Index(rest, []byte(key))
+		if i < 0 {
+			return ""
+		}
+		rest = bytes.TrimLeft(rest[i+len(key):], " \t\r\n")
+
+		var value []byte
+		switch {
+		case len(rest) > 0 && rest[0] == '(':
+			value = pdfLiteral(rest[1:])
+		case len(rest) > 1 && rest[0] == '<' && rest[1] != '<':
+			end := bytes.IndexByte(rest, '>')
+			if end < 0 {
+				return ""
+			}
+			value, _ = hex.DecodeString(string(bytes.Join(bytes.Fields(rest[1:end]), nil)))
+		}
+
+		if s := strings.TrimSpace(pdfText(value)); s != "" {
+			return s
+		}
+	}
+}
+
+// pdfLiteral decodes literal string up to its closing parenthesis.
+func pdfLiteral(data []byte) []byte {
+	var result []byte
+	depth := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return result
+			}
+			depth--
+		case '\\':
+			i++
+			if i == len(data) {
+				return result
+			}
+			switch c = data[i]; c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := int(c - '0')
+					for j := 0; j < 2 && i+1 < len(data) && data[i+1] >= '0' && data[i+1] <= '7'; j++ {
+						i++
+						v = v*8 + int(data[i]-'0')
+					}
+					c = byte(v)
+				}
+			}
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// pdfText decodes text string, which is either UTF-16 with byte order mark or PDFDocEncoding.
+func pdfText(data []byte) string {
+	if len(data) >= 2 && data[0] == 0xfe && data[1] == 0xff {
+		units := make([]uint16, 0, len(data)/2)
+		for i := 2; i+1 < len(data); i += 2 {
+			units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	if bytes.HasPrefix(data, []byte("\xef\xbb\xbf")) {
+		return string(data[3:])
+	}
+
+	// PDFDocEncoding matches Latin-1 in printable range.
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// parseAuthorName splits author name. fileAs is used when it's in form of "Last, First Middle".
+func parseAuthorName(name, fileAs string) (model.Author, bool) {
+	if last, rest, ok := strings.Cut(fileAs, ","); ok && strings.TrimSpace(last) != "" {
+		parts := strings.Fields(rest)
+		author := model.Author{LastName: strings.TrimSpace(last)}
+		if len(parts) > 0 {
+			author.FirstName = parts[0]
+			author.MiddleName = strings.Join(parts[1:], " ")
+		}
+		return author, true
+	}
+
+	parts := strings.Fields(name)
+	switch len(parts) {
+	case 0:
+		return model.Author{}, false
+	case 1:
+		return model.Author{LastName: parts[0]}, true
+	default:
+		return model.Author{
+			FirstName:  parts[0],
+			MiddleName: strings.Join(parts[1:len(parts)-1], " "),
+			LastName:   parts[len(parts)-1],
+		}, true
+	}
+}
+
+// decodeElement unmarshals first element with local name into v.
+func decodeElement(data []byte, name string, v any) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return errElementNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == name {
+			return d.DecodeElement(v, &start)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+// Package exporter writes subset of imported collection as a new .inpx file.
+package exporter
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/shemanaev/inpxer/internal/archive"
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/db"
+	"github.com/shemanaev/inpxer/internal/library"
+	"github.com/shemanaev/inpxer/internal/model"
+	"github.com/shemanaev/inpxer/pkg/inpx"
+)
+
+// searchPageSize is an amount of books fetched from full-text index at once.
+const searchPageSize = 1000
+
+// defaultArchive is a name of archive for repacked books without one.
+const defaultArchive = "files"
+
+// Options controls export of collection.
+type Options struct {
+	// Query selects books with full-text search, all books are exported if empty.
+	Query string
+	// Field limits search to single field, e.g. Title or Authors.
+	Field string
+	// Languages, Genres and Exts filter books, matching is case-insensitive. Empty filter matches everything.
+	Languages []string
+	Genres    []string
+	Exts      []string
+	// Repack is a folder where files of exported books are copied to, files aren't copied if empty.
+	Repack  string
+	Name    string
+	Comment string
+}
+
+// Run exports books matching options to file.
+func Run(ctx context.Context, cfg *config.MyConfig, out string, opts Options) error {
+	index, err := db.Open(cfg.IndexPath, cfg.Storage)
+	if err != nil {
+		log.Printf("Error opening index: %s", cfg.IndexPath)
+		return cli.Exit(err.Error(), 1)
+	}
+	defer index.Close()
+
+	books, err := selectBooks(ctx, index, &opts)
+	if err != nil {
+		log.Printf("Error selecting books: %s", cfg.IndexPath)
+		return cli.Exit(err.Error(), 1)
+	}
+
+	if opts.Repack != "" {
+		resolver, err := library.NewResolver(cfg)
+		if err != nil {
+			log.Printf("Error opening library: %v", err)
+			return cli.Exit(err.Error(), 1)
+		}
+
+		books, err = repack(ctx, resolver, opts.Repack, books)
+		if err != nil {
+			log.Printf("Error copying books to: %s", opts.Repack)
+			return cli.Exit(err.Error(), 1)
+		}
+	}
+
+	if err := writeCatalog(out, books, &opts); err != nil {
+		log.Printf("Error writing catalog: %s", out)
+		return cli.Exit(err.Error(), 1)
+	}
+
+	log.Printf("Exported: %d books", len(books))
+	return nil
+}
+
+// selectBooks returns books matching options ordered by id.
+func selectBooks(ctx context.Context, index *db.Store, opts *Options) ([]*model.Book, error) {
+	var books []*model.Book
+	add := func(book *model.Book) {
+		if opts.match(book) {
+			books = append(books, book)
+		}
+	}
+
+	if opts.Query == "" {
+		for book, err := range index.Books("") {
+			if err != nil {
+				return nil, err
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			add(book)
+		}
+	} else {
+		for page := 0; ; page++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			result, err := index.SearchByField(opts.Field, opts.Query, page, searchPageSize)
+			if err != nil {
+				return nil, err
+			}
+			for _, book := range result.Hits {
+				add(book)
+			}
+
+			if len(result.Hits) == 0 || uint64((page+1)*searchPageSize) >= result.Total {
+				break
+			}
+		}
+	}
+
+	slices.SortFunc(books, func(a, b *model.Book) int {
+		return compareIds(a.LibId, b.LibId)
+	})
+	return books, nil
+}
+
+func (o *Options) match(book *model.Book) bool {
+	if len(o.Languages) > 0 && !containsFold(o.Languages, book.Language) {
+		return false
+	}
+	if len(o.Exts) > 0 && !containsFold(o.Exts, book.File.Ext) {
+		return false
+	}
+	if len(o.Genres) > 0 && !slices.ContainsFunc(book.Genres, func(g string) bool { return containsFold(o.Genres, g) }) {
+		return false
+	}
+	return true
+}
+
+// writeCatalog writes collection file. Partially written file is removed on error.
+func writeCatalog(out string, books []*model.Book, opts *Options) (err error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(out)
+		}
+	}()
+
+	w := inpx.NewWriter(f)
+	w.Name = opts.Name
+	w.FileName = filepath.Base(out)
+	w.Comment = opts.Comment
+	w.ExtraFields = extraFields(books)
+	for _, book := range books {
+		record := book.ToInpx()
+		if record.File.Archive == "" {
+			record.File.Archive = defaultArchive
+		}
+		if err := w.Add(record); err != nil {
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+// repack copies files of books to archives in dir named after .inp files of books.
+// Files stored in archives are copied without recompression. Books which files are missing are skipped.
+func repack(ctx context.Context, resolver *library.Resolver, dir string, books []*model.Book) ([]*model.Book, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Group books by target archive keeping order of source archives, so each of them is opened once.
+	sorted := slices.Clone(books)
+	slices.SortStableFunc(sorted, func(a, b *model.Book) int {
+		if c := strings.Compare(targetArchive(a), targetArchive(b)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.File.Folder, b.File.Folder)
+	})
+
+	// Books are sorted by source archive, so only the last one is kept open.
+	src := archive.NewCache(1)
+	defer src.Close()
+
+	var result []*model.Book
+	var zw *zip.Writer
+	var zf *os.File
+	var current string
+	var names map[string]bool
+	closeTarget := func() error {
+		if zw == nil {
+			return nil
+		}
+		err := zw.Close()
+		if cerr := zf.Close(); err == nil {
+			err = cerr
+		}
+		// None of books were found.
+		if err == nil && len(names) == 0 {
+			err = os.Remove(zf.Name())
+		}
+		zw = nil
+		return err
+	}
+	defer closeTarget()
+
+	for _, book := range sorted {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		target := targetArchive(book)
+		if target != current {
+			if err := closeTarget(); err != nil {
+				return nil, err
+			}
+
+			var err error
+			zf, err = os.Create(filepath.Join(dir, target+".zip"))
+			if err != nil {
+				return nil, err
+			}
+			zw = zip.NewWriter(zf)
+			current = target
+			names = make(map[string]bool)
+		}
+
+		name := book.File.Name
+		if !book.File.IsArchived() {
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+		}
+		if names[name] {
+			name = fmt.Sprintf("%s_%s", name, book.LibId)
+		}
+
+		err := copyBook(zw, src, resolver, book, name+"."+book.File.Ext)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, archive.ErrNotFound) {
+			log.Printf("File of book %s not found, skipped: %v", book.LibId, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		names[name] = true
+
+		copied := *book
+		copied.File = model.File{
+			Name:    name,
+			Size:    book.File.Size,
+			Ext:     book.File.Ext,
+			Archive: target,
+		}
+		result = append(result, &copied)
+	}
+
+	if err := closeTarget(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(result, func(a, b *model.Book) int {
+		return compareIds(a.LibId, b.LibId)
+	})
+	return result, nil
+}
+
+// copyBook writes file of book to archive under given name.
+func copyBook(zw *zip.Writer, src *archive.Cache, resolver *library.Resolver, book *model.Book, name string) error {
+	if book.File.IsArchived() {
+		archivePath := book.File.ArchivePath()
+		f, err := src.OpenRaw(resolver.Store(archivePath), archivePath, fmt.Sprintf("%s.%s", book.File.Name, book.File.Ext))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		header := f.Header
+		header.Name = name
+		w, err := zw.CreateRaw(&header)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(w, f)
+		return err
+	}
+
+	filename := path.Join(book.File.Folder, book.File.Name)
+	f, err := resolver.Store(filename).Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: f.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// extraFields returns sorted names of extra fields used by books.
+func extraFields(books []*model.Book) []string {
+	var names []string
+	for _, book := range books {
+		for name := range book.Extra {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func targetArchive(book *model.Book) string {
+	if book.File.Archive == "" {
+		return defaultArchive
+	}
+	return book.File.Archive
+}
+
+func containsFold(list []string, s string) bool {
+	return slices.ContainsFunc(list, func(v string) bool { return strings.EqualFold(v, s) })
+}
+
+// compareIds orders numeric ids by value.
+func compareIds(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,169 @@
+package exporter
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/db"
+	"github.com/shemanaev/inpxer/internal/filestore/s3test"
+	"github.com/shemanaev/inpxer/internal/model"
+	"github.com/shemanaev/inpxer/pkg/inpx"
+)
+
+func newTestLibrary(t *testing.T) *config.MyConfig {
+	t.Helper()
+
+	library := t.TempDir()
+	f, err := os.Create(filepath.Join(library, "books-1.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, err := zw.Create("1.fb2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("archived book"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := os.MkdirAll(filepath.Join(library, "loose"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(library, "loose", "Book.epub"), []byte("loose book"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.MyConfig{
+		IndexPath:   t.Name(),
+		LibraryPath: library,
+		Language:    "en",
+		Storage:     "memory",
+	}
+	index, err := db.Create(cfg.IndexPath, cfg.Language, cfg.Storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	err = index.AddBooks([]*model.Book{
+		{LibId: "1", Title: "Solaris", Genres: []string{"sf"}, Language: "ru", Extra: map[string]string{"PUBLISHER": "Мир"}, File: model.File{Name: "1", Size: 13, Ext: "fb2", Archive: "books-1"}},
+		{LibId: "2", Title: "Missing", Genres: []string{"sf"}, Language: "ru", File: model.File{Name: "2", Size: 10, Ext: "fb2", Archive: "books-1"}},
+		{LibId: "10", Title: "Eden", Genres: []string{"prose", "SF"}, Language: "RU", File: model.File{Name: "Book.epub", Size: 10, Ext: "epub", Folder: "loose", Archive: "loose"}},
+		{LibId: "3", Title: "Dune", Genres: []string{"sf"}, Language: "en", File: model.File{Name: "3", Size: 10, Ext: "fb2", Archive: "books-1"}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+func readCatalog(t *testing.T, name string) []*inpx.Book {
+	t.Helper()
+
+	p, err := inpx.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var books []*inpx.Book
+	for book, err := range p.Books(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		books = append(books, book)
+	}
+	return books
+}
+
+func TestExportFilters(t *testing.T) {
+	cfg := newTestLibrary(t)
+	out := filepath.Join(t.TempDir(), "subset.inpx")
+
+	err := Run(context.Background(), cfg, out, Options{Languages: []string{"ru"}, Genres: []string{"sf"}, Name: "Subset"})
+	assert.NoError(t, err)
+
+	var ids []int
+	for _, b := range readCatalog(t, out) {
+		ids = append(ids, b.LibId)
+	}
+	assert.ElementsMatch(t, []int{1, 2, 10}, ids)
+	assert.Equal(t, map[string]string{"PUBLISHER": "Мир"}, readCatalog(t, out)[0].Extra)
+
+	err = Run(context.Background(), cfg, out, Options{Query: "dune", Exts: []string{"FB2"}})
+	assert.NoError(t, err)
+	books := readCatalog(t, out)
+	if assert.Len(t, books, 1) {
+		assert.Equal(t, 3, books[0].LibId)
+		assert.Equal(t, inpx.File{Name: "3", Size: 10, Ext: "fb2", Archive: "books-1"}, books[0].File)
+	}
+}
+
+func TestExportRepack(t *testing.T) {
+	cfg := newTestLibrary(t)
+	dir := t.TempDir()
+	out := filepath.Join(dir, "subset.inpx")
+
+	err := Run(context.Background(), cfg, out, Options{Languages: []string{"ru"}, Repack: dir})
+	assert.NoError(t, err)
+
+	books := readCatalog(t, out)
+	if !assert.Len(t, books, 2) {
+		return
+	}
+	assert.Equal(t, inpx.File{Name: "1", Size: 13, Ext: "fb2", Archive: "books-1"}, books[0].File)
+	assert.Equal(t, inpx.File{Name: "Book", Size: 10, Ext: "epub", Archive: "loose"}, books[1].File)
+
+	for name, content := range map[string]string{"books-1.zip/1.fb2": "archived book", "loose.zip/Book.epub": "loose book"} {
+		archive, member := filepath.Split(name)
+		zr, err := zip.OpenReader(filepath.Join(dir, filepath.Clean(archive)))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		f, err := zr.Open(member)
+		if assert.NoError(t, err) {
+			data, err := io.ReadAll(f)
+			assert.NoError(t, err)
+			assert.Equal(t, content, string(data))
+			f.Close()
+		}
+		zr.Close()
+	}
+}
+
+func TestExportRepackS3(t *testing.T) {
+	cfg := newTestLibrary(t)
+	data, err := os.ReadFile(filepath.Join(cfg.LibraryPath, "books-1.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Loose book is missing in storage.
+	storage := s3test.NewServer(t)
+	storage.Put("books", "library/books-1.zip", data)
+	cfg.LibraryPath = "s3://books/library"
+	cfg.S3 = *storage.Config()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "subset.inpx")
+	err = Run(context.Background(), cfg, out, Options{Languages: []string{"ru"}, Repack: dir})
+	assert.NoError(t, err)
+
+	books := readCatalog(t, out)
+	if assert.Len(t, books, 1) {
+		assert.Equal(t, 1, books[0].LibId)
+	}
+	_, err = os.Stat(filepath.Join(dir, "loose.zip"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,88 @@
+// Package filestore provides access to library files stored on local disk or in S3-compatible object storage.
+package filestore
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shemanaev/inpxer/internal/config"
+)
+
+// File is a library file opened for reading.
+// Reads at offset don't require reading preceding data, so members of large archives are read directly.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Size() int64
+	ModTime() time.Time
+}
+
+// Store opens files by path relative to library folder, with forward slashes as separators.
+type Store interface {
+	Open(name string) (File, error)
+	Stat(name string) (fs.FileInfo, error)
+}
+
+// New returns store of library folder. Folders set as "s3://bucket/prefix" are stored in object storage.
+func New(folder string, cfg *config.S3) (Store, error) {
+	if bucket, ok := strings.CutPrefix(folder, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(bucket, "/")
+		return NewS3(cfg, bucket, prefix)
+	}
+	return Dir(folder), nil
+}
+
+// Dir is a folder on local disk.
+type Dir string
+
+func (d Dir) Open(name string) (File, error) {
+	f, err := os.Open(d.Path(name))
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &localFile{File: f, stat: stat}, nil
+}
+
+func (d Dir) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(d.Path(name))
+}
+
+// Path returns full path of file.
+func (d Dir) Path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(path.Clean(name)))
+}
+
+// LocalPath returns path of file on local disk, empty for files stored elsewhere.
+func LocalPath(f File) string {
+	if lf, ok := f.(*localFile); ok {
+		return lf.Name()
+	}
+	return ""
+}
+
+type localFile struct {
+	*os.File
+	stat fs.FileInfo
+}
+
+func (f *localFile) Size() int64 {
+	return f.stat.Size()
+}
+
+func (f *localFile) ModTime() time.Time {
+	return f.stat.ModTime()
+}
@@ -0,0 +1,265 @@
+package filestore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shemanaev/inpxer/internal/config"
+)
+
+// blockSize is a size of ranged reads, smaller reads are served from the last read block.
+const blockSize = 256 << 10
+
+// S3 is a folder in bucket of S3-compatible object storage. Objects are addressed in path style,
+// so it works with MinIO and other self-hosted storages without DNS setup.
+type S3 struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+// NewS3 returns store of objects in bucket with keys starting with prefix.
+// Requests are sent without signature when access key isn't set, e.g. for public buckets.
+func NewS3(cfg *config.S3, bucket, prefix string) (*S3, error) {
+	if bucket == "" {
+		return nil, errors.New("bucket of s3 folder isn't set")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint: %q", endpoint)
+	}
+
+	accessKey, secretKey := cfg.AccessKey, cfg.SecretKey
+	if accessKey == "" {
+		accessKey, secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	return &S3{
+		client:    http.DefaultClient,
+		endpoint:  u,
+		bucket:    bucket,
+		prefix:    strings.Trim(prefix, "/"),
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+	}, nil
+}
+
+func (s *S3) String() string {
+	return "s3://" + path.Join(s.bucket, s.prefix)
+}
+
+func (s *S3) Open(name string) (File, error) {
+	resp, err := s.do(http.MethodHead, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	o := &object{
+		s3:   s,
+		name: name,
+		info: newObjectInfo(name, resp),
+		etag: resp.Header.Get("ETag"),
+	}
+	o.sr = io.NewSectionReader(o, 0, o.info.size)
+	return o, nil
+}
+
+func (s *S3) Stat(name string) (fs.FileInfo, error) {
+	resp, err := s.do(http.MethodHead, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return newObjectInfo(name, resp), nil
+}
+
+// do sends request for object, responses with unsuccessful status are returned as errors.
+func (s *S3) do(method, name string, header http.Header) (*http.Response, error) {
+	key := path.Join("/", s.bucket, s.prefix, name)
+	u := *s.endpoint
+	// Path is sent escaped the same way it's signed.
+	u.Path = strings.TrimSuffix(s.endpoint.Path, "/") + key
+	u.RawPath = strings.TrimSuffix(s.endpoint.EscapedPath(), "/") + escape(key, false)
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.accessKey != "" {
+		sign(req, s.accessKey, s.secretKey, s.region, time.Now())
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, &fs.PathError{Op: "open", Path: s.String() + "/" + name, Err: fs.ErrNotExist}
+	case resp.StatusCode >= http.StatusMultipleChoices:
+		resp.Body.Close()
+		return nil, &fs.PathError{Op: "open", Path: s.String() + "/" + name, Err: errors.New(resp.Status)}
+	}
+
+	return resp, nil
+}
+
+// object is an object of S3 storage opened for reading.
+// Data is read with ranged requests on demand, object replaced while open fails to read.
+type object struct {
+	s3   *S3
+	name string
+	info *objectInfo
+	etag string
+	sr   *io.SectionReader
+
+	mu       sync.Mutex
+	block    []byte
+	blockOff int64
+}
+
+func (o *object) Read(p []byte) (int, error) {
+	return o.sr.Read(p)
+}
+
+func (o *object) Seek(offset int64, whence int) (int64, error) {
+	return o.sr.Seek(offset, whence)
+}
+
+func (o *object) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	var n int
+	for n < len(p) && off < o.info.size {
+		var m int
+		var err error
+		if len(p)-n >= blockSize {
+			m, err = o.fetch(p[n:], off)
+		} else {
+			m, err = o.readBlock(p[n:], off)
+		}
+		n += m
+		off += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (o *object) Close() error {
+	o.mu.Lock()
+	o.block = nil
+	o.mu.Unlock()
+	return nil
+}
+
+func (o *object) Size() int64 {
+	return o.info.size
+}
+
+func (o *object) ModTime() time.Time {
+	return o.info.modTime
+}
+
+// readBlock reads p from block containing off, requesting block when it isn't the last read one.
+func (o *object) readBlock(p []byte, off int64) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	start := off - off%blockSize
+	if o.block == nil || o.blockOff != start {
+		buf := o.block
+		if buf == nil {
+			buf = make([]byte, blockSize)
+		}
+		n, err := o.fetch(buf[:blockSize], start)
+		if err != nil {
+			o.block = nil
+			return 0, err
+		}
+		o.block = buf[:n]
+		o.blockOff = start
+	}
+
+	return copy(p, o.block[off-start:]), nil
+}
+
+// fetch reads p from off with single ranged request, reading stops at the end of object.
+func (o *object) fetch(p []byte, off int64) (int, error) {
+	end := min(off+int64(len(p)), o.info.size)
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", off, end-1)}}
+	if o.etag != "" {
+		header.Set("If-Match", o.etag)
+	}
+
+	resp, err := o.s3.do(http.MethodGet, o.name, header)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("range requests aren't supported for %s/%s: %s", o.s3, o.name, resp.Status)
+	}
+
+	return io.ReadFull(resp.Body, p[:end-off])
+}
+
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func newObjectInfo(name string, resp *http.Response) *objectInfo {
+	info := &objectInfo{name: path.Base(name)}
+	info.size, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	info.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info
+}
+
+func (i *objectInfo) Name() string       { return i.name }
+func (i *objectInfo) Size() int64        { return i.size }
+func (i *objectInfo) Mode() fs.FileMode  { return 0444 }
+func (i *objectInfo) ModTime() time.Time { return i.modTime }
+func (i *objectInfo) IsDir() bool        { return false }
+func (i *objectInfo) Sys() any           { return nil }
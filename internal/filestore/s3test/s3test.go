@@ -0,0 +1,114 @@
+// Package s3test provides in-memory S3-compatible storage for tests, a stand-in for local MinIO.
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shemanaev/inpxer/internal/config"
+)
+
+const (
+	AccessKey = "inpxer"
+	SecretKey = "inpxer-secret"
+)
+
+// Server serves objects put to it with path-style urls, supporting HEAD and ranged GET requests.
+// Requests must have Authorization header with AccessKey.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]*object
+	sent    int64
+}
+
+type object struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// NewServer starts server, it's closed when test finishes.
+func NewServer(t *testing.T) *Server {
+	t.Helper()
+
+	s := &Server{objects: make(map[string]*object)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Config returns configuration to connect to server.
+func (s *Server) Config() *config.S3 {
+	return &config.S3{
+		Endpoint:  s.URL,
+		AccessKey: AccessKey,
+		SecretKey: SecretKey,
+	}
+}
+
+// Put stores object with key in bucket.
+func (s *Server) Put(bucket, key string, data []byte) {
+	sum := md5.Sum(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+key] = &object{
+		data:    data,
+		etag:    `"` + hex.EncodeToString(sum[:]) + `"`,
+		modTime: time.Now().Truncate(time.Second),
+	}
+}
+
+// Sent returns number of bytes of objects sent in responses.
+func (s *Server) Sent() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sent
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+AccessKey+"/") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.Lock()
+	obj, ok := s.objects[strings.TrimPrefix(r.URL.Path, "/")]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("ETag", obj.etag)
+	cw := &countingWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, "", obj.modTime, bytes.NewReader(obj.data))
+
+	s.mu.Lock()
+	s.sent += cw.n
+	s.mu.Unlock()
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
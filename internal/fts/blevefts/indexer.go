@@ -47,6 +47,19 @@ func Create(path, language string) (*Indexer, error) {
 	return &res, nil
 }
 
+// NewMemOnly creates index which is kept in memory only.
+func NewMemOnly(language string) (*Indexer, error) {
+	idx, err := bleve.NewMemOnly(createBookMapping(getAnalyzer(language)))
+	if err != nil {
+		return nil, err
+	}
+
+	res := Indexer{
+		index: idx,
+	}
+	return &res, nil
+}
+
 func (i *Indexer) Close() error {
 	return i.index.Close()
 }
@@ -76,11 +89,25 @@ func (i *Indexer) AddBooks(books []*fts.Book, partial bool) error {
 	return nil
 }
 
+func (i *Indexer) DeleteBooks(ids []string) error {
+	batch := i.index.NewBatch()
+	for _, id := range ids {
+		batch.Delete(id)
+	}
+
+	return i.index.Batch(batch)
+}
+
 func (i *Indexer) SearchByField(field, s string, page, pageSize int) (*fts.SearchResult, error) {
+	return i.Search(field, s, page*pageSize, pageSize)
+}
+
+// Search returns up to size hits starting from hit number from.
+func (i *Indexer) Search(field, s string, from, size int) (*fts.SearchResult, error) {
 	query := bleve.NewMatchQuery(s)
 	query.SetField(field)
 	query.SetOperator(blevequery.MatchQueryOperatorAnd)
-	search := bleve.NewSearchRequestOptions(query, pageSize, page*pageSize, false)
+	search := bleve.NewSearchRequestOptions(query, size, from, false)
 
 	switch field {
 	case "Title":
@@ -155,8 +182,12 @@ func createBookMapping(analyzer string) *mapping.IndexMappingImpl {
 	indexedDate.IncludeInAll = false
 	bookMapping.AddFieldMappingsAt("PubDate", indexedDate)
 
+	// Extra fields are mapped dynamically as text, e.g. Extra.PUBLISHER.
+	bookMapping.AddSubDocumentMapping("Extra", bleve.NewDocumentMapping())
+
 	indexMapping := bleve.NewIndexMapping()
 	indexMapping.DefaultAnalyzer = analyzer
+	indexMapping.StoreDynamic = false
 	indexMapping.AddDocumentMapping("book", bookMapping)
 	return indexMapping
 }
@@ -14,7 +14,9 @@ type Indexer interface {
 	Create(path, language string) (*Indexer, error)
 	Close() error
 	AddBooks(books []*Book, partial bool) error
+	DeleteBooks(ids []string) error
 	SearchByField(field, s string, page, pageSize int) (*SearchResult, error)
+	Search(field, s string, from, size int) (*SearchResult, error)
 	GetMostRecentBooks(count int) ([]string, error)
 }
 
@@ -25,6 +27,8 @@ type Book struct {
 	Series   string
 	SeriesNo int
 	PubDate  time.Time
+	// Extra are indexed fields of collection unknown to parser.
+	Extra map[string]string
 }
 
 func (b *Book) BleveType() string {
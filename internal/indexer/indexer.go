@@ -1,9 +1,16 @@
 package indexer
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
 	"time"
 
 	"github.com/chelnak/ysmrr"
@@ -19,23 +26,68 @@ import (
 
 const batchSize = 1000
 
-func Run(cfg *config.MyConfig, filename string, keepDeleted, partial bool) error {
-	collection, err := inpx.Open(filename)
+// maxReportedErrors limits amount of malformed records printed after import.
+const maxReportedErrors = 100
+
+// Options controls import of collection.
+type Options struct {
+	// KeepDeleted imports records marked as deleted.
+	KeepDeleted bool
+	// Partial only adds new records keeping existing index.
+	Partial bool
+	// MaxErrors is an amount of malformed records skipped before import is aborted, negative means unlimited.
+	MaxErrors int
+	// Workers is an amount of .inp files decoded concurrently, all CPUs are used if not set.
+	Workers int
+	// Encoding of collection text, inpx_encoding from config is used if not set.
+	Encoding string
+}
+
+func Run(ctx context.Context, cfg *config.MyConfig, filename string, opts Options) error {
+	keepDeleted, partial := opts.KeepDeleted, opts.Partial
+	source := filepath.Base(filename)
+	if filename == "-" {
+		tmp, err := spoolStdin()
+		if err != nil {
+			log.Printf("Error reading inpx from stdin")
+			return cli.Exit(err.Error(), 1)
+		}
+		defer os.Remove(tmp)
+
+		filename = tmp
+		source = "stdin"
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	encodingName := opts.Encoding
+	if encodingName == "" {
+		encodingName = cfg.InpxEncoding
+	}
+	enc, err := inpx.LookupEncoding(encodingName)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	collection, err := inpx.Open(filename, inpx.WithLenient(), inpx.WithWorkers(workers), inpx.WithEncoding(enc))
 	if err != nil {
 		log.Printf("Error opening inpx: %s", filename)
 		return cli.Exit(err.Error(), 1)
 	}
 	defer collection.Close()
 
+	checksum, err := fileSHA256(filename)
+	if err != nil {
+		log.Printf("Error reading inpx: %s", filename)
+		return cli.Exit(err.Error(), 1)
+	}
+
 	if !partial {
-		// Delete old index.
-		if _, err := os.Stat(cfg.IndexPath); !os.IsNotExist(err) {
-			log.Println("Deleting old index...")
-			err = os.RemoveAll(cfg.IndexPath)
-			if err != nil {
-				log.Printf("Error deleting old index: %s", cfg.IndexPath)
-				return cli.Exit(err.Error(), 1)
-			}
+		if err := deleteIndex(cfg); err != nil {
+			return cli.Exit(err.Error(), 1)
 		}
 	}
 
@@ -44,6 +96,7 @@ func Run(cfg *config.MyConfig, filename string, keepDeleted, partial bool) error
 		log.Printf("Error opening or creating index: %s", cfg.IndexPath)
 		return cli.Exit(err.Error(), 1)
 	}
+	idx.IndexExtraFields(cfg.ExtraFieldNames())
 	defer idx.Close()
 
 	sm := ysmrr.NewSpinnerManager(
@@ -56,10 +109,29 @@ func Run(cfg *config.MyConfig, filename string, keepDeleted, partial bool) error
 
 	start := time.Now()
 
-	var recordsCount, deletedCount int
+	var recordsCount, deletedCount, importedCount int
+	var parseErrors []*inpx.ParseError
 	duplicates := make(map[int]int)
 	books := make([]*model.Book, 0)
-	for book := range collection.Stream() {
+	for book, err := range collection.Books(ctx) {
+		var parseErr *inpx.ParseError
+		if errors.As(err, &parseErr) {
+			parseErrors = append(parseErrors, parseErr)
+			if opts.MaxErrors >= 0 && len(parseErrors) > opts.MaxErrors {
+				s.Error()
+				sm.Stop()
+				reportParseErrors(parseErrors)
+				return cli.Exit(fmt.Sprintf("too many malformed records, more than %d", opts.MaxErrors), 1)
+			}
+			continue
+		}
+
+		if err != nil {
+			s.Error()
+			log.Printf("Error parsing inpx file: %s", filename)
+			return cli.Exit(err.Error(), 1)
+		}
+
 		recordsCount = recordsCount + 1
 		if book.Deleted && !keepDeleted {
 			deletedCount = deletedCount + 1
@@ -75,7 +147,8 @@ func Run(cfg *config.MyConfig, filename string, keepDeleted, partial bool) error
 		}
 
 		if len(books) > batchSize {
-			err := idx.AddBooks(books, partial)
+			added, err := addBooks(idx, books, partial)
+			importedCount += added
 			if err != nil {
 				s.Error()
 				return cli.Exit(err.Error(), 1)
@@ -86,7 +159,8 @@ func Run(cfg *config.MyConfig, filename string, keepDeleted, partial bool) error
 	}
 
 	if len(books) > 0 {
-		err := idx.AddBooks(books, partial)
+		added, err := addBooks(idx, books, partial)
+		importedCount += added
 		if err != nil {
 			s.Error()
 			return cli.Exit(err.Error(), 1)
@@ -101,16 +175,137 @@ func Run(cfg *config.MyConfig, filename string, keepDeleted, partial bool) error
 		}
 	}
 
-	if err := collection.Err(); err != nil {
+	info := &db.IndexInfo{
+		Name:         collection.Name,
+		Id:           collection.Id,
+		Comment:      collection.Comment,
+		Version:      collection.Version,
+		ImportedAt:   time.Now(),
+		Source:       source,
+		SourceSHA256: checksum,
+		Partial:      partial,
+		Processed:    recordsCount,
+		Imported:     importedCount,
+		Duplicates:   duplicatesCount,
+		Deleted:      deletedCount,
+		Malformed:    len(parseErrors),
+	}
+	if err := idx.SetInfo(info); err != nil {
 		s.Error()
-		log.Printf("Error parsing inpx file: %s", filename)
+		log.Printf("Error saving collection info: %s", cfg.IndexPath)
 		return cli.Exit(err.Error(), 1)
 	}
 
 	s.UpdateMessage("Done")
 	s.Complete()
 	elapsed := time.Since(start)
-	log.Printf("Processed: %d, imported: %d, duplicates: %d, deleted: %d. (Took %s)", recordsCount, recordsCount-duplicatesCount-deletedCount, duplicatesCount, deletedCount, elapsed)
+	sm.Stop()
+	reportWarnings(collection.Warnings())
+	reportParseErrors(parseErrors)
+	log.Printf("Processed: %d, imported: %d, duplicates: %d, deleted: %d, malformed: %d. (Took %s)", info.Processed, info.Imported, info.Duplicates, info.Deleted, info.Malformed, elapsed)
+
+	return nil
+}
 
+// addBooks adds books to index and returns how many of them are new.
+// Books already stored are skipped by partial import and aren't counted.
+func addBooks(idx *db.Store, books []*model.Book, partial bool) (int, error) {
+	added := len(books)
+	if partial {
+		ids := make([]string, len(books))
+		for i, book := range books {
+			ids[i] = book.LibId
+		}
+		existing, err := idx.CountExisting(ids)
+		if err != nil {
+			return 0, err
+		}
+		added -= existing
+	}
+
+	if err := idx.AddBooks(books, partial); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// deleteIndex removes index of library, so it's imported from scratch.
+// Memory storage has nothing on disk, index path is only its name.
+func deleteIndex(cfg *config.MyConfig) error {
+	if db.IsMemory(cfg.Storage) {
+		return nil
+	}
+	if _, err := os.Stat(cfg.IndexPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	log.Println("Deleting old index...")
+	if err := os.RemoveAll(cfg.IndexPath); err != nil {
+		log.Printf("Error deleting old index: %s", cfg.IndexPath)
+		return err
+	}
 	return nil
 }
+
+// reportParseErrors prints skipped records.
+func reportParseErrors(errs []*inpx.ParseError) {
+	if len(errs) == 0 {
+		return
+	}
+
+	log.Printf("Skipped malformed records: %d", len(errs))
+	for i, err := range errs {
+		if i == maxReportedErrors {
+			log.Printf("...and %d more", len(errs)-maxReportedErrors)
+			break
+		}
+		log.Printf("  %s.inp:%d: %s: %s", err.Archive, err.Line, err.Reason, err.Raw)
+	}
+}
+
+// reportWarnings prints records with suspicious text.
+func reportWarnings(warnings []*inpx.Warning) {
+	if len(warnings) == 0 {
+		return
+	}
+
+	log.Printf("Records with suspicious text: %d", len(warnings))
+	for i, w := range warnings {
+		if i == maxReportedErrors {
+			log.Printf("...and %d more", len(warnings)-maxReportedErrors)
+			break
+		}
+		log.Printf("  %s", w)
+	}
+}
+
+// spoolStdin copies standard input to temporary file, since collection must be seekable.
+func spoolStdin() (string, error) {
+	f, err := os.CreateTemp("", "inpxer-*.inpx")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, os.Stdin); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
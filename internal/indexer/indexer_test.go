@@ -0,0 +1,102 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/db"
+)
+
+const testInpx = "../../pkg/inpx/testdata/flibusta_fb2_local.inpx"
+
+func readInfo(t *testing.T, cfg *config.MyConfig) (*db.IndexInfo, int) {
+	t.Helper()
+
+	idx, err := db.Open(cfg.IndexPath, cfg.Storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	info, err := idx.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := idx.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info, count
+}
+
+func TestPartialImportCountsNewBooks(t *testing.T) {
+	cfg := &config.MyConfig{IndexPath: t.Name(), Storage: "memory", Language: "en"}
+
+	err := Run(context.Background(), cfg, testInpx, Options{MaxErrors: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, count := readInfo(t, cfg)
+	assert.Equal(t, count, info.Imported)
+
+	// Re-import of the same collection adds nothing.
+	err = Run(context.Background(), cfg, testInpx, Options{MaxErrors: -1, Partial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ = readInfo(t, cfg)
+	assert.Equal(t, 0, info.Imported)
+	assert.Positive(t, info.Processed)
+
+	idx, err := db.Open(cfg.IndexPath, cfg.Storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.DeleteBooks([]string{"166370", "166372"}); err != nil {
+		t.Fatal(err)
+	}
+	idx.Close()
+
+	err = Run(context.Background(), cfg, testInpx, Options{MaxErrors: -1, Partial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, count = readInfo(t, cfg)
+	assert.Equal(t, 2, info.Imported)
+	assert.Equal(t, info.Processed-info.Duplicates-info.Deleted, count)
+}
+
+func TestReindex(t *testing.T) {
+	cfg := &config.MyConfig{IndexPath: t.TempDir(), Storage: "bolt", Language: "ru"}
+	if err := Run(context.Background(), cfg, testInpx, Options{MaxErrors: -1}); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func() *db.SearchResult {
+		idx, err := db.Open(cfg.IndexPath, cfg.Storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer idx.Close()
+
+		book, err := idx.GetBookById("166370")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := idx.SearchByField("Title", book.Title, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	before := search()
+	assert.Positive(t, before.Total)
+	if err := Reindex(cfg); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before, search())
+}
@@ -0,0 +1,45 @@
+package indexer
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/chelnak/ysmrr"
+	"github.com/chelnak/ysmrr/pkg/animations"
+	"github.com/chelnak/ysmrr/pkg/colors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/db"
+)
+
+// Reindex rebuilds full-text index from books stored in index.
+func Reindex(cfg *config.MyConfig) error {
+	sm := ysmrr.NewSpinnerManager(
+		ysmrr.WithAnimation(animations.Dots),
+		ysmrr.WithSpinnerColor(colors.FgHiBlue),
+	)
+	s := sm.AddSpinner("Reindexing...")
+	sm.Start()
+	defer sm.Stop()
+
+	start := time.Now()
+
+	var indexed int
+	err := db.Rebuild(cfg.IndexPath, cfg.Language, cfg.Storage, cfg.ExtraFieldNames(), func(done, total int) {
+		indexed = done
+		s.UpdateMessage(fmt.Sprintf("Processed: %d of %d", done, total))
+	})
+	if err != nil {
+		s.Error()
+		log.Printf("Error rebuilding index: %s", cfg.IndexPath)
+		return cli.Exit(err.Error(), 1)
+	}
+
+	s.UpdateMessage("Done")
+	s.Complete()
+	log.Printf("Reindexed: %d. (Took %s)", indexed, time.Since(start))
+
+	return nil
+}
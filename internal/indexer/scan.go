@@ -0,0 +1,220 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chelnak/ysmrr"
+	"github.com/chelnak/ysmrr/pkg/animations"
+	"github.com/chelnak/ysmrr/pkg/colors"
+	"github.com/urfave/cli/v2"
+
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/db"
+	"github.com/shemanaev/inpxer/internal/model"
+	"github.com/shemanaev/inpxer/internal/scanner"
+)
+
+// Scan imports books from library folder. Files unchanged since previous scan aren't read again,
+// books of removed files are deleted. Index imported from .inpx is replaced on the first scan.
+func Scan(ctx context.Context, cfg *config.MyConfig, dir string) error {
+	if strings.HasPrefix(dir, "s3://") {
+		return cli.Exit("folders in object storage can't be scanned, import catalog of library instead", 1)
+	}
+
+	folders := cfg.Folders()
+	if !slices.ContainsFunc(folders, func(folder string) bool { return sameDir(dir, folder) }) {
+		log.Printf("Warning: books are served from library_path (%s), not from %s", strings.Join(folders, ", "), dir)
+	}
+	if len(folders) > 1 {
+		log.Printf("Warning: library_path has several folders, only books of %s are kept in index", dir)
+	}
+
+	idx, err := db.Create(cfg.IndexPath, cfg.Language, cfg.Storage)
+	if err != nil {
+		log.Printf("Error opening or creating index: %s", cfg.IndexPath)
+		return cli.Exit(err.Error(), 1)
+	}
+	idx.IndexExtraFields(cfg.ExtraFieldNames())
+	defer func() { idx.Close() }()
+
+	state, err := idx.ScanState()
+	if err != nil {
+		log.Printf("Error reading scan state: %s", cfg.IndexPath)
+		return cli.Exit(err.Error(), 1)
+	}
+
+	if state == nil {
+		count, err := idx.Count()
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+
+		if count > 0 {
+			idx.Close()
+			if err := deleteIndex(cfg); err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+
+			idx, err = db.Create(cfg.IndexPath, cfg.Language, cfg.Storage)
+			if err != nil {
+				log.Printf("Error opening or creating index: %s", cfg.IndexPath)
+				return cli.Exit(err.Error(), 1)
+			}
+			idx.IndexExtraFields(cfg.ExtraFieldNames())
+		}
+	}
+
+	sm := ysmrr.NewSpinnerManager(
+		ysmrr.WithAnimation(animations.Dots),
+		ysmrr.WithSpinnerColor(colors.FgHiBlue),
+	)
+	s := sm.AddSpinner("Scanning...")
+	sm.Start()
+	defer sm.Stop()
+
+	start := time.Now()
+
+	sc := scanner.New(dir)
+	for _, file := range state {
+		for key, id := range file.Books {
+			if v, err := strconv.Atoi(id); err == nil {
+				sc.Reserve(key, v)
+			}
+		}
+	}
+
+	newState := make(map[string]*db.ScannedFile)
+	unchanged := 0
+	sc.Skip = func(path string, info fs.FileInfo) bool {
+		file, ok := state[path]
+		if !ok || file.Size != info.Size() || !file.ModTime.Equal(info.ModTime()) {
+			newState[path] = &db.ScannedFile{
+				ModTime: info.ModTime(),
+				Size:    info.Size(),
+				Books:   make(map[string]string),
+			}
+			return false
+		}
+
+		newState[path] = file
+		unchanged += len(file.Books)
+		return true
+	}
+	// Books of file which can't be read are kept and the file is read again on the next scan.
+	sc.Failed = func(path string, err error) {
+		file := newState[path]
+		file.ModTime, file.Size = time.Time{}, -1
+		if old, ok := state[path]; ok {
+			for key, id := range old.Books {
+				if _, ok := file.Books[key]; !ok {
+					file.Books[key] = id
+				}
+			}
+		}
+	}
+
+	var processed int
+	books := make([]*model.Book, 0)
+	err = sc.Scan(func(e *scanner.Entry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		book := model.NewBook(e.Book)
+		newState[e.Path].Books[e.Key] = book.LibId
+		books = append(books, book)
+		processed++
+
+		if len(books) > batchSize {
+			if err := idx.AddBooks(books, false); err != nil {
+				return err
+			}
+			s.UpdateMessage(fmt.Sprintf("Processed: %d", processed))
+			books = make([]*model.Book, 0)
+		}
+
+		return nil
+	})
+	if err == nil && len(books) > 0 {
+		err = idx.AddBooks(books, false)
+	}
+	if err != nil {
+		s.Error()
+		log.Printf("Error scanning library: %s", dir)
+		return cli.Exit(err.Error(), 1)
+	}
+
+	// Books of removed files and books removed from changed archives.
+	kept := make(map[string]bool)
+	for _, file := range newState {
+		for _, id := range file.Books {
+			kept[id] = true
+		}
+	}
+	var removed []string
+	for _, file := range state {
+		for _, id := range file.Books {
+			if !kept[id] {
+				removed = append(removed, id)
+			}
+		}
+	}
+	if len(removed) > 0 {
+		if err := idx.DeleteBooks(removed); err != nil {
+			s.Error()
+			return cli.Exit(err.Error(), 1)
+		}
+	}
+
+	if err := idx.SetScanState(newState); err != nil {
+		s.Error()
+		log.Printf("Error saving scan state: %s", cfg.IndexPath)
+		return cli.Exit(err.Error(), 1)
+	}
+
+	total, err := idx.Count()
+	if err != nil {
+		s.Error()
+		return cli.Exit(err.Error(), 1)
+	}
+
+	name := dir
+	if abs, err := filepath.Abs(dir); err == nil {
+		name = abs
+	}
+	info := &db.IndexInfo{
+		Name:       filepath.Base(name),
+		ImportedAt: time.Now(),
+		Source:     dir,
+		Processed:  processed,
+		Imported:   total,
+	}
+	if err := idx.SetInfo(info); err != nil {
+		s.Error()
+		log.Printf("Error saving collection info: %s", cfg.IndexPath)
+		return cli.Exit(err.Error(), 1)
+	}
+
+	s.UpdateMessage("Done")
+	s.Complete()
+	elapsed := time.Since(start)
+	sm.Stop()
+	log.Printf("Read: %d, unchanged: %d, removed: %d, total: %d. (Took %s)", processed, unchanged, len(removed), total, elapsed)
+
+	return nil
+}
+
+// sameDir reports whether paths point to the same directory.
+func sameDir(a, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && a == b
+}
@@ -0,0 +1,94 @@
+package indexer
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/db"
+)
+
+func writeZip(t *testing.T, name string, members ...string) {
+	t.Helper()
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, member := range members {
+		w, err := zw.Create(member)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("<FictionBook><description><title-info><book-title>" + member + "</book-title></title-info></description></FictionBook>"))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readScanState(t *testing.T, cfg *config.MyConfig) (map[string]*db.ScannedFile, int) {
+	t.Helper()
+
+	idx, err := db.Open(cfg.IndexPath, cfg.Storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	state, err := idx.ScanState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := idx.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state, count
+}
+
+func TestScanKeepsBooksOfUnreadableArchive(t *testing.T) {
+	lib := t.TempDir()
+	cfg := &config.MyConfig{IndexPath: t.TempDir(), Storage: "bolt", Language: "en", LibraryPath: lib}
+	archive := filepath.Join(lib, "a.zip")
+	writeZip(t, archive, "1.fb2", "2.fb2")
+
+	if err := Scan(context.Background(), cfg, lib); err != nil {
+		t.Fatal(err)
+	}
+	state, count := readScanState(t, cfg)
+	assert.Equal(t, 2, count)
+	books := state["a.zip"].Books
+
+	if err := os.WriteFile(archive, []byte("not an archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Scan(context.Background(), cfg, lib); err != nil {
+		t.Fatal(err)
+	}
+	state, count = readScanState(t, cfg)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, books, state["a.zip"].Books)
+	assert.Equal(t, int64(-1), state["a.zip"].Size)
+
+	// Archive is read again once it's fixed.
+	writeZip(t, archive, "1.fb2", "2.fb2", "3.fb2")
+	if err := Scan(context.Background(), cfg, lib); err != nil {
+		t.Fatal(err)
+	}
+	state, count = readScanState(t, cfg)
+	assert.Equal(t, 3, count)
+	if assert.Len(t, state["a.zip"].Books, 3) {
+		for key, id := range books {
+			assert.Equal(t, id, state["a.zip"].Books[key])
+		}
+	}
+}
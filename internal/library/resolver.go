@@ -0,0 +1,96 @@
+// Package library finds files of books in library spread across several folders, local or in object storage.
+package library
+
+import (
+	"path"
+	"slices"
+	"sync"
+
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/filestore"
+)
+
+// Resolver finds folders archives and files of library are stored in.
+// Found folders are cached, so every file is looked up once.
+type Resolver struct {
+	folders []string
+	roots   []*config.Root
+	stores  map[string]filestore.Store
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+func NewResolver(cfg *config.MyConfig) (*Resolver, error) {
+	r := &Resolver{
+		folders: cfg.Folders(),
+		roots:   cfg.LibraryRoots,
+		stores:  make(map[string]filestore.Store),
+		cache:   make(map[string]string),
+	}
+
+	folders := slices.Clone(r.folders)
+	for _, root := range r.roots {
+		folders = append(folders, root.Path)
+	}
+	for _, folder := range folders {
+		store, err := filestore.New(folder, &cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		r.stores[folder] = store
+	}
+
+	return r, nil
+}
+
+// Lookup returns folder file with path relative to library is stored in.
+// Folders of roots which pattern matches name are checked first, then library folders in order.
+func (r *Resolver) Lookup(name string) (string, bool) {
+	name = path.Clean(name)
+
+	r.mu.Lock()
+	folder, ok := r.cache[name]
+	r.mu.Unlock()
+	if ok {
+		return folder, true
+	}
+
+	for _, folder := range r.candidates(name) {
+		if _, err := r.stores[folder].Stat(name); err == nil {
+			r.mu.Lock()
+			r.cache[name] = folder
+			r.mu.Unlock()
+			return folder, true
+		}
+	}
+
+	return "", false
+}
+
+// Store returns store of folder file with path relative to library is found in.
+// When file isn't found, store of the first folder is returned, so opening file reports an error.
+func (r *Resolver) Store(name string) filestore.Store {
+	folder, ok := r.Lookup(name)
+	if !ok {
+		folder = r.candidates(path.Clean(name))[0]
+	}
+	return r.stores[folder]
+}
+
+// Forget drops cached folder of file, e.g. after it was moved.
+func (r *Resolver) Forget(name string) {
+	r.mu.Lock()
+	delete(r.cache, path.Clean(name))
+	r.mu.Unlock()
+}
+
+func (r *Resolver) candidates(name string) []string {
+	var res []string
+	for _, root := range r.roots {
+		if ok, _ := path.Match(root.Pattern, name); ok {
+			res = append(res, root.Path)
+		}
+	}
+	return append(res, r.folders...)
+}
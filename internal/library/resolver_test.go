@@ -0,0 +1,96 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/filestore"
+	"github.com/shemanaev/inpxer/internal/filestore/s3test"
+)
+
+func createFile(t *testing.T, name string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte("book"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	first, second, third := t.TempDir(), t.TempDir(), t.TempDir()
+	createFile(t, filepath.Join(first, "fb2-1.zip"))
+	createFile(t, filepath.Join(second, "fb2-1.zip"))
+	createFile(t, filepath.Join(second, "fb2-2.zip"))
+	createFile(t, filepath.Join(second, "loose", "Book.epub"))
+	createFile(t, filepath.Join(third, "fb2-1.zip"))
+	createFile(t, filepath.Join(third, "usr-1.zip"))
+
+	r, err := NewResolver(&config.MyConfig{
+		LibraryPaths: config.Paths{first, second},
+		LibraryRoots: []*config.Root{{Pattern: "usr-*.zip", Path: third}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folder, ok := r.Lookup("fb2-1.zip")
+	assert.True(t, ok)
+	assert.Equal(t, first, folder)
+
+	folder, ok = r.Lookup("fb2-2.zip")
+	assert.True(t, ok)
+	assert.Equal(t, second, folder)
+
+	// Roots matching pattern are checked first.
+	folder, ok = r.Lookup("usr-1.zip")
+	assert.True(t, ok)
+	assert.Equal(t, third, folder)
+
+	assert.Equal(t, filestore.Dir(second), r.Store("loose/Book.epub"))
+
+	_, ok = r.Lookup("fb2-3.zip")
+	assert.False(t, ok)
+	assert.Equal(t, filestore.Dir(first), r.Store("fb2-3.zip"))
+
+	// Found folders are cached until forgotten.
+	if err := os.Remove(filepath.Join(first, "fb2-1.zip")); err != nil {
+		t.Fatal(err)
+	}
+	folder, _ = r.Lookup("fb2-1.zip")
+	assert.Equal(t, first, folder)
+
+	r.Forget("fb2-1.zip")
+	folder, _ = r.Lookup("fb2-1.zip")
+	assert.Equal(t, second, folder)
+}
+
+func TestResolverS3(t *testing.T) {
+	local := t.TempDir()
+	createFile(t, filepath.Join(local, "fb2-1.zip"))
+	server := s3test.NewServer(t)
+	server.Put("books", "library/fb2-2.zip", []byte("book"))
+
+	cfg := &config.MyConfig{
+		LibraryPaths: config.Paths{local, "s3://books/library"},
+		S3:           *server.Config(),
+	}
+	r, err := NewResolver(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	folder, ok := r.Lookup("fb2-2.zip")
+	assert.True(t, ok)
+	assert.Equal(t, "s3://books/library", folder)
+	assert.Equal(t, filestore.Dir(local), r.Store("fb2-1.zip"))
+
+	_, err = NewResolver(&config.MyConfig{LibraryPath: "s3://"})
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"fmt"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -40,6 +41,12 @@ type Book struct {
 	File     File
 	PubDate  time.Time
 	Language string
+	// Extra keeps fields of collection unknown to parser by their names.
+	Extra map[string]string
+	// Broken is set by verify when file of book is missing or damaged.
+	Broken bool
+	// Library is id of library book belongs to when several libraries are served, it isn't stored.
+	Library string
 }
 
 var seriesSuffixes = []string{"[a]", "[p]", "[m]"}
@@ -75,9 +82,53 @@ func NewBook(book *inpx.Book) *Book {
 		},
 		PubDate:  book.PublishedDate,
 		Language: book.Language,
+		Extra:    book.Extra,
 	}
 }
 
+// ToInpx returns collection record of book.
+func (b *Book) ToInpx() *inpx.Book {
+	var authors []inpx.Author
+	for _, a := range b.Authors {
+		authors = append(authors, inpx.Author{
+			LastName:   a.LastName,
+			FirstName:  a.FirstName,
+			MiddleName: a.MiddleName,
+		})
+	}
+
+	id, _ := strconv.Atoi(b.LibId)
+	return &inpx.Book{
+		Authors:  authors,
+		Genres:   b.Genres,
+		Title:    b.Title,
+		Series:   b.Series,
+		SeriesNo: b.SeriesNo,
+		File: inpx.File{
+			Name:    b.File.Name,
+			Size:    b.File.Size,
+			Ext:     b.File.Ext,
+			Folder:  b.File.Folder,
+			Archive: b.File.Archive,
+		},
+		LibId:         id,
+		PublishedDate: b.PubDate,
+		Language:      b.Language,
+		Extra:         b.Extra,
+	}
+}
+
+// LibraryIdSeparator separates library id from book id.
+const LibraryIdSeparator = ":"
+
+// Id returns id of book prefixed by its library, just LibId if library isn't set.
+func (b *Book) Id() string {
+	if b.Library == "" {
+		return b.LibId
+	}
+	return b.Library + LibraryIdSeparator + b.LibId
+}
+
 func (b *Book) CleanTitle() string {
 	return cleanTitleRe.ReplaceAllString(b.Title, "")
 }
@@ -91,17 +142,22 @@ func (b *Book) PublishedAt() string {
 }
 
 func (f *File) IsArchived() bool {
-	return f.Folder == "" || strings.HasSuffix(f.Folder, ".zip")
+	return f.Folder == "" || isZip(f.Folder)
 }
 
 func (f *File) ArchivePath() string {
-	if strings.HasSuffix(f.Folder, ".zip") {
+	if isZip(f.Folder) {
 		return f.Folder
 	}
 
 	return fmt.Sprintf("%s.zip", f.Archive)
 }
 
+// isZip reports whether folder is a zip archive, extension case is ignored as by library scanner.
+func isZip(folder string) bool {
+	return strings.EqualFold(path.Ext(folder), ".zip")
+}
+
 func (a Author) String() string {
 	var name string
 	if a.FirstName == "" && a.MiddleName == "" {
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileArchive(t *testing.T) {
+	tests := []struct {
+		file     File
+		archived bool
+		path     string
+	}{
+		{File{Archive: "fb2-1-100"}, true, "fb2-1-100.zip"},
+		{File{Folder: "scanned/books.zip", Archive: "scanned_books"}, true, "scanned/books.zip"},
+		{File{Folder: "scanned/BOOKS.ZIP", Archive: "scanned_BOOKS"}, true, "scanned/BOOKS.ZIP"},
+		{File{Folder: "loose", Archive: "loose"}, false, ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.archived, tt.file.IsArchived(), tt.file.Folder)
+		if tt.archived {
+			assert.Equal(t, tt.path, tt.file.ArchivePath(), tt.file.Folder)
+		}
+	}
+}
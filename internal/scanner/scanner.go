@@ -0,0 +1,301 @@
+// Package scanner builds collection records from a folder of book files.
+package scanner
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/shemanaev/inpxer/internal/ebook"
+	"github.com/shemanaev/inpxer/pkg/inpx"
+)
+
+// maxLibId limits generated ids, so they stay readable.
+const maxLibId = 1_000_000_000
+
+// maxMemberInMemory limits size of compressed archive members unpacked to memory, larger ones are unpacked to temporary file.
+var maxMemberInMemory int64 = 16 << 20
+
+// rootArchive is a name of .inp file for books stored in the root of library.
+const rootArchive = "files"
+
+// Entry is a book found in library.
+type Entry struct {
+	// Path is a path of file relative to library root. Books from the same zip archive share it.
+	Path string
+	// Key identifies book between scans, it's a path of archive member for books in archives.
+	Key  string
+	Book *inpx.Book
+}
+
+// Scanner reads books from library folder. Zip archives are scanned for books inside them,
+// other supported files are added as is.
+type Scanner struct {
+	root string
+	// ids maps allocated ids to keys of books.
+	ids  map[int]string
+	keys map[string]int
+
+	// Skip is called for every file before it's read, books of skipped files aren't reported.
+	Skip func(path string, info fs.FileInfo) bool
+	// Failed is called for file which can't be read, or some books of which can't be read.
+	Failed func(path string, err error)
+}
+
+func New(root string) *Scanner {
+	return &Scanner{
+		root: root,
+		ids:  make(map[int]string),
+		keys: make(map[string]int),
+	}
+}
+
+// Reserve assigns id to book from previous scan, so it's kept when book is changed
+// and not taken by another one when book is skipped.
+func (s *Scanner) Reserve(key string, id int) {
+	s.ids[id] = key
+	s.keys[key] = id
+}
+
+// Scan walks library in lexical order and calls fn for every book found.
+// Files which can't be read are reported and skipped.
+func (s *Scanner) Scan(fn func(*Entry) error) error {
+	return filepath.WalkDir(s.root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		isArchive := strings.EqualFold(path.Ext(rel), ".zip")
+		if !isArchive && !isSupported(rel) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if s.Skip != nil && s.Skip(rel, info) {
+			return nil
+		}
+
+		if isArchive {
+			return s.scanArchive(name, rel, info, fn)
+		}
+
+		f, err := os.Open(name)
+		if err != nil {
+			log.Printf("Can't open `%s`: %v", rel, err)
+			s.fail(rel, err)
+			return nil
+		}
+		defer f.Close()
+
+		dir := path.Dir(rel)
+		archive := rootArchive
+		if dir != "." {
+			archive = archiveName(dir)
+		}
+
+		book := s.newBook(rel, path.Base(rel), f, info.Size(), info)
+		book.File.Name = path.Base(rel)
+		book.File.Folder = dir
+		book.File.Archive = archive
+		return fn(&Entry{Path: rel, Key: rel, Book: book})
+	})
+}
+
+// scanArchive adds books stored in zip file. Folder of such books points to the archive.
+func (s *Scanner) scanArchive(name, rel string, info fs.FileInfo, fn func(*Entry) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		log.Printf("Can't open archive `%s`: %v", rel, err)
+		s.fail(rel, err)
+		return nil
+	}
+	defer file.Close()
+
+	zr, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		log.Printf("Can't open archive `%s`: %v", rel, err)
+		s.fail(rel, err)
+		return nil
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isSupported(f.Name) {
+			continue
+		}
+
+		r, release, err := memberReader(file, f)
+		if err != nil {
+			log.Printf("Can't read `%s` in archive `%s`: %v", f.Name, rel, err)
+			s.fail(rel, err)
+			continue
+		}
+
+		key := rel + "/" + f.Name
+		book := s.newBook(key, path.Base(f.Name), r, int64(f.UncompressedSize64), info)
+		release()
+		book.File.Name = strings.TrimSuffix(f.Name, path.Ext(f.Name))
+		book.File.Folder = rel
+		book.File.Archive = archiveName(strings.TrimSuffix(rel, path.Ext(rel)))
+		if err := fn(&Entry{Path: rel, Key: key, Book: book}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Scanner) fail(path string, err error) {
+	if s.Failed != nil {
+		s.Failed(path, err)
+	}
+}
+
+// memberReader returns reader of archive member and function releasing it.
+// Stored members are read from archive directly. Compressed ones are unpacked to memory
+// or to temporary file when they are larger than maxMemberInMemory. Only head of FB2 is unpacked.
+func memberReader(archive io.ReaderAt, f *zip.File) (io.ReaderAt, func(), error) {
+	if f.Method == zip.Store {
+		offset, err := f.DataOffset()
+		if err != nil {
+			return nil, nil, err
+		}
+		return io.NewSectionReader(archive, offset, int64(f.UncompressedSize64)), func() {}, nil
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	size := int64(f.UncompressedSize64)
+	if strings.EqualFold(path.Ext(f.Name), ".fb2") {
+		size = min(size, ebook.MaxFB2HeadSize)
+	}
+
+	if size <= maxMemberInMemory {
+		data, err := io.ReadAll(io.LimitReader(r, size))
+		if err != nil {
+			return nil, nil, err
+		}
+		return bytes.NewReader(data), func() {}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "inpxer-scan-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return tmp, release, nil
+}
+
+// newBook creates record from file metadata. File name is used as title when metadata can't be read.
+func (s *Scanner) newBook(key, filename string, r io.ReaderAt, size int64, info fs.FileInfo) *inpx.Book {
+	ext := strings.TrimPrefix(path.Ext(filename), ".")
+	book := &inpx.Book{
+		Title:         strings.TrimSuffix(filename, path.Ext(filename)),
+		LibId:         s.allocateId(key),
+		PublishedDate: info.ModTime(),
+		File: inpx.File{
+			Size: int(size),
+			Ext:  ext,
+		},
+	}
+
+	meta, err := readMetadata(strings.ToLower(ext), r, size)
+	if err != nil {
+		log.Printf("Can't read metadata of `%s`: %v", key, err)
+		return book
+	}
+
+	if meta.Title != "" {
+		book.Title = meta.Title
+	}
+	for _, a := range meta.Authors {
+		book.Authors = append(book.Authors, inpx.Author{
+			LastName:   a.LastName,
+			FirstName:  a.FirstName,
+			MiddleName: a.MiddleName,
+		})
+	}
+	book.Genres = meta.Genres
+	book.Series = meta.Series
+	book.SeriesNo = meta.SeriesNo
+	book.Language = meta.Language
+
+	return book
+}
+
+func readMetadata(ext string, r io.ReaderAt, size int64) (*ebook.Metadata, error) {
+	switch ext {
+	case "fb2":
+		return ebook.ReadFB2(io.NewSectionReader(r, 0, size))
+	case "epub":
+		return ebook.ReadEPUB(r, size)
+	case "pdf":
+		return ebook.ReadPDF(r, size)
+	case "cbz":
+		return ebook.ReadCBZ(r, size)
+	}
+	return &ebook.Metadata{}, nil
+}
+
+// allocateId returns id derived from key of book, so it's kept between rescans.
+// Collisions are resolved by taking the next free id.
+func (s *Scanner) allocateId(key string) int {
+	if id, ok := s.keys[key]; ok {
+		return id
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	id := int(h.Sum32()%maxLibId) + 1
+	for {
+		if _, taken := s.ids[id]; !taken {
+			break
+		}
+		id = id%maxLibId + 1
+	}
+	s.Reserve(key, id)
+	return id
+}
+
+// archiveName returns name of .inp file for folder.
+func archiveName(dir string) string {
+	return strings.ReplaceAll(dir, "/", "_")
+}
+
+func isSupported(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".fb2", ".epub", ".pdf", ".cbz":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,251 @@
+package scanner
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/ebook"
+	"github.com/shemanaev/inpxer/pkg/inpx"
+)
+
+const testFB2 = `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0">
+<description><title-info>
+<genre>sf</genre>
+<author><first-name>Isaac</first-name><last-name>Asimov</last-name></author>
+<book-title>Foundation</book-title>
+<lang>en</lang>
+<sequence name="Foundation" number="1"/>
+</title-info></description>
+<body><section><p>Text</p></section></body>
+</FictionBook>`
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeZip(t *testing.T, name string, files map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func scan(t *testing.T, root string) []*inpx.Book {
+	t.Helper()
+	var books []*inpx.Book
+	err := New(root).Scan(func(e *Entry) error {
+		books = append(books, e.Book)
+		return nil
+	})
+	assert.NoError(t, err)
+	return books
+}
+
+func TestScan(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "Foundation.fb2"), testFB2)
+	writeFile(t, filepath.Join(root, "misc", "new", "Broken.FB2"), "not a book")
+	writeFile(t, filepath.Join(root, "notes.txt"), "skipped")
+	writeZip(t, filepath.Join(root, "archives", "fb2-1.zip"), map[string]string{"100.fb2": testFB2, "readme.txt": "skipped"})
+
+	books := scan(t, root)
+	if !assert.Len(t, books, 3) {
+		return
+	}
+
+	assert.Equal(t, "Foundation", books[0].Title)
+	assert.Equal(t, []inpx.Author{{LastName: "Asimov", FirstName: "Isaac"}}, books[0].Authors)
+	assert.Equal(t, []string{"sf"}, books[0].Genres)
+	assert.Equal(t, "Foundation", books[0].Series)
+	assert.Equal(t, 1, books[0].SeriesNo)
+	assert.Equal(t, "en", books[0].Language)
+	assert.Equal(t, inpx.File{Name: "Foundation.fb2", Size: len(testFB2), Ext: "fb2", Folder: ".", Archive: rootArchive}, books[0].File)
+
+	assert.Equal(t, "Foundation", books[1].Title)
+	assert.Equal(t, inpx.File{Name: "100", Size: len(testFB2), Ext: "fb2", Folder: "archives/fb2-1.zip", Archive: "archives_fb2-1"}, books[1].File)
+
+	assert.Equal(t, "Broken", books[2].Title)
+	assert.Equal(t, inpx.File{Name: "Broken.FB2", Size: 10, Ext: "FB2", Folder: "misc/new", Archive: "misc_new"}, books[2].File)
+
+	ids := map[int]bool{}
+	for _, b := range books {
+		assert.Positive(t, b.LibId)
+		ids[b.LibId] = true
+	}
+	assert.Len(t, ids, 3)
+
+	// Ids don't depend on other files in library.
+	writeFile(t, filepath.Join(root, "Another.fb2"), testFB2)
+	rescanned := scan(t, root)
+	assert.Equal(t, books[0].LibId, rescanned[1].LibId)
+	assert.Equal(t, books[2].LibId, rescanned[3].LibId)
+}
+
+func TestScanFormats(t *testing.T) {
+	root := t.TempDir()
+	writeZip(t, filepath.Join(root, "Comic.cbz"), map[string]string{
+		"ComicInfo.xml": `<?xml version="1.0"?><ComicInfo><Title>Pilot</Title><Series>Space</Series><Number>2</Number>` +
+			`<Writer>John Smith, Jane Doe</Writer><Genre>Sci-Fi, Comedy</Genre><LanguageISO>en</LanguageISO></ComicInfo>`,
+		"001.jpg": "image",
+	})
+	writeFile(t, filepath.Join(root, "Paper.pdf"), "%PDF-1.4\n1 0 obj\n<< /Title (Report \\(final\\)) /Author <FEFF0041006E006E0061> >>\nendobj\n%%EOF")
+
+	books := scan(t, root)
+	if !assert.Len(t, books, 2) {
+		return
+	}
+
+	assert.Equal(t, "Pilot", books[0].Title)
+	assert.Equal(t, "Space", books[0].Series)
+	assert.Equal(t, 2, books[0].SeriesNo)
+	assert.Equal(t, []inpx.Author{{LastName: "Smith", FirstName: "John"}, {LastName: "Doe", FirstName: "Jane"}}, books[0].Authors)
+	assert.Equal(t, []string{"Sci-Fi", "Comedy"}, books[0].Genres)
+	assert.Equal(t, "cbz", books[0].File.Ext)
+
+	assert.Equal(t, "Report (final)", books[1].Title)
+	assert.Equal(t, []inpx.Author{{LastName: "Anna"}}, books[1].Authors)
+	assert.Equal(t, "pdf", books[1].File.Ext)
+}
+
+func TestScanArchiveMembers(t *testing.T) {
+	// Compressed members larger than that are unpacked to temporary file.
+	defer func(size int64) { maxMemberInMemory = size }(maxMemberInMemory)
+	maxMemberInMemory = 1024
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	var cbz bytes.Buffer
+	zw := zip.NewWriter(&cbz)
+	w, _ := zw.Create("ComicInfo.xml")
+	w.Write([]byte(`<?xml version="1.0"?><ComicInfo><Title>Pilot</Title></ComicInfo>`))
+	w, _ = zw.CreateHeader(&zip.FileHeader{Name: "001.jpg", Method: zip.Store})
+	w.Write(bytes.Repeat([]byte("image"), 1000))
+	zw.Close()
+
+	// Only head of long FB2 is unpacked.
+	longFB2 := strings.Replace(testFB2, "<p>Text</p>", strings.Repeat("<p>Text</p>", ebook.MaxFB2HeadSize/10), 1)
+
+	root := t.TempDir()
+	f, err := os.Create(filepath.Join(root, "books.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw = zip.NewWriter(f)
+	for _, m := range []struct {
+		name    string
+		method  uint16
+		content []byte
+	}{
+		{"stored.cbz", zip.Store, cbz.Bytes()},
+		{"deflated.cbz", zip.Deflate, cbz.Bytes()},
+		{"long.fb2", zip.Deflate, []byte(longFB2)},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: m.name, Method: m.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(m.content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	books := scan(t, root)
+	if !assert.Len(t, books, 3) {
+		return
+	}
+	assert.Equal(t, "Pilot", books[0].Title)
+	assert.Equal(t, cbz.Len(), books[0].File.Size)
+	assert.Equal(t, "Pilot", books[1].Title)
+	assert.Equal(t, cbz.Len(), books[1].File.Size)
+	assert.Equal(t, "Foundation", books[2].Title)
+	assert.Equal(t, len(longFB2), books[2].File.Size)
+
+	// Temporary files are removed.
+	entries, err := os.ReadDir(tmp)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestScanIncremental(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.fb2"), testFB2)
+	writeFile(t, filepath.Join(root, "b.fb2"), testFB2)
+
+	s := New(root)
+	s.Reserve("b.fb2", 42)
+	s.Skip = func(path string, info fs.FileInfo) bool {
+		return path == "a.fb2"
+	}
+
+	var entries []*Entry
+	err := s.Scan(func(e *Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "b.fb2", entries[0].Path)
+		assert.Equal(t, 42, entries[0].Book.LibId)
+	}
+}
+
+func TestAllocateIdCollision(t *testing.T) {
+	s := New("")
+	first := s.allocateId("a")
+	assert.Equal(t, first, s.allocateId("a"))
+
+	s = New("")
+	s.Reserve("b", first)
+	assert.Equal(t, first%maxLibId+1, s.allocateId("a"))
+}
+
+func TestScanFailed(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "broken.zip"), "not an archive")
+	writeFile(t, filepath.Join(root, "a.fb2"), testFB2)
+
+	s := New(root)
+	var failed []string
+	s.Failed = func(path string, err error) {
+		assert.Error(t, err)
+		failed = append(failed, path)
+	}
+	err := s.Scan(func(e *Entry) error { return nil })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"broken.zip"}, failed)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shemanaev/inpxer/internal/db"
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+// makeETag returns strong entity tag built from parts.
+func makeETag(parts ...any) string {
+	h := fnv.New64a()
+	for _, p := range parts {
+		_, _ = fmt.Fprintf(h, "%v|", p)
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum64())
+}
+
+// fileValidators returns ETag and modification time for book file served in given variant.
+// Rewritten files also depend on catalog data, so index modification time is taken into account.
+func fileValidators(index *db.Store, book *model.Book, modTime time.Time, size int64, variant string, rewritten bool) (string, time.Time) {
+	if rewritten {
+		if indexTime, err := index.ModTime(); err == nil && indexTime.After(modTime) {
+			modTime = indexTime
+		}
+		variant += "+metadata"
+	}
+
+	return makeETag(book.Id(), variant, modTime.UnixNano(), size), modTime
+}
+
+// modTimer is an index or group of indexes pages are generated from.
+type modTimer interface {
+	ModTime() (time.Time, error)
+}
+
+// checkIndexNotModified handles conditional request for page generated from index contents.
+// Returns modification time of the page and true if client's copy is fresh and response is already written.
+func checkIndexNotModified(w http.ResponseWriter, r *http.Request, index modTimer) (time.Time, bool) {
+	modTime, _ := index.ModTime()
+	return checkPageNotModified(w, r, modTime)
+}
+
+// checkPageNotModified handles conditional request for generated page.
+// Pages depend on templates as well, so they are never older than the build.
+// Clients are asked to revalidate the page on every use.
+func checkPageNotModified(w http.ResponseWriter, r *http.Request, modTime time.Time) (time.Time, bool) {
+	if modTime.Before(BuildDate) {
+		modTime = BuildDate
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	etag := makeETag(r.URL.RequestURI(), modTime.UnixNano())
+	return modTime, checkNotModified(w, r, etag, modTime)
+}
+
+// checkNotModified sets validators and reports whether client's copy is fresh,
+// in which case 304 Not Modified is written.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else {
+		ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || modTime.IsZero() || modTime.Truncate(time.Second).After(ims) {
+			return false
+		}
+	}
+
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Disposition")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches performs weak comparison of If-None-Match header value with etag.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -2,41 +2,72 @@ package server
 
 import (
 	"archive/zip"
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"mime"
 	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/essentialkaos/translit/v2"
 	"github.com/go-chi/chi/v5"
 
+	"github.com/shemanaev/inpxer/internal/archive"
 	"github.com/shemanaev/inpxer/internal/config"
 	"github.com/shemanaev/inpxer/internal/db"
+	"github.com/shemanaev/inpxer/internal/filestore"
+	"github.com/shemanaev/inpxer/internal/library"
 	"github.com/shemanaev/inpxer/internal/model"
 )
 
+// streamBufferSize bounds memory used per request when streaming compressed archive members.
+const streamBufferSize = 64 << 10
+
 type DownloadHandler struct {
-	cfg *config.MyConfig
+	cfg       *config.MyConfig
+	libraries []*config.MyConfig
+	// resolvers find files of libraries by library id.
+	resolvers map[string]*library.Resolver
+	archives  *archive.Cache
 }
 
-func NewDownloadHandler(cfg *config.MyConfig) *DownloadHandler {
-	return &DownloadHandler{
-		cfg: cfg,
+func NewDownloadHandler(cfg *config.MyConfig, archives *archive.Cache) (*DownloadHandler, error) {
+	libraries := cfg.AllLibraries()
+	resolvers := make(map[string]*library.Resolver, len(libraries))
+	for _, lib := range libraries {
+		resolver, err := library.NewResolver(lib)
+		if err != nil {
+			return nil, err
+		}
+		resolvers[lib.LibraryId] = resolver
 	}
+
+	return &DownloadHandler{
+		cfg:       cfg,
+		libraries: libraries,
+		resolvers: resolvers,
+		archives:  archives,
+	}, nil
 }
 
 func (h *DownloadHandler) Download(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	index, err := db.Open(h.cfg.IndexPath, h.cfg.Storage)
+	lib, bookId := findLibrary(h.libraries, id)
+	if lib == nil {
+		log.Printf("Library of id: %s not found", id)
+		notFound(w, id)
+		return
+	}
+
+	index, err := db.Open(lib.IndexPath, lib.Storage)
 	if err != nil {
 		log.Printf("Error opening index: %v", err)
 		internalServerError(w)
@@ -44,37 +75,39 @@ func (h *DownloadHandler) Download(w http.ResponseWriter, r *http.Request) {
 	}
 	defer index.Close()
 
-	book, err := index.GetBookById(id)
+	book, err := index.GetBookById(bookId)
 	if err != nil {
 		log.Printf("File with id: %s not found in index: %v", id, err)
 		notFound(w, id)
 		return
 	}
+	book.Library = lib.LibraryId
 
-	if book.File.IsArchived() {
-		data, err := h.getFileFromArchive(book)
-		if err != nil {
-			notFound(w, id)
-			return
-		}
-
-		filename := fmt.Sprintf("%s.%s", book.File.Name, book.File.Ext)
-		log.Printf("File `%s` for id %s served directly from archive (%s)", filename, id, book.File.Archive)
+	file, err := h.openBook(lib, book)
+	if err != nil {
+		notFound(w, id)
+		return
+	}
+	defer file.Close()
 
-		addFilenameToHeader(w, book.Title, filename)
-		http.ServeContent(w, r, filename, time.Now(), bytes.NewReader(data))
+	if book.File.IsArchived() {
+		log.Printf("File `%s` for id %s served directly from archive (%s)", file.Name, id, book.File.Archive)
 	} else {
-		filename, err := h.getDirectFilePath(book)
-		if err != nil {
-			notFound(w, id)
-			return
-		}
+		log.Printf("File `%s` for id %s served directly (%s)", file.Name, id, book.File.Folder)
+	}
 
-		log.Printf("File `%s` for id %s served directly from fs", filename, id)
+	rewrite := h.shouldRewriteMetadata(r, file.Name)
+	etag, modTime := fileValidators(index, book, file.ModTime, file.Size, "", rewrite)
+	if checkNotModified(w, r, etag, modTime) {
+		return
+	}
 
-		addFilenameToHeader(w, book.Title, book.File.Name)
-		http.ServeFile(w, r, filename)
+	h.addFilenameToHeader(w, book, file.Name)
+	if rewrite {
+		serveWithMetadata(w, r, book, file.Name, file.Reader, file.Size)
+		return
 	}
+	serveBookFile(w, r, file)
 }
 
 func (h *DownloadHandler) DownloadConverted(w http.ResponseWriter, r *http.Request) {
@@ -95,7 +128,14 @@ func (h *DownloadHandler) DownloadConverted(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	index, err := db.Open(h.cfg.IndexPath, h.cfg.Storage)
+	lib, bookId := findLibrary(h.libraries, id)
+	if lib == nil {
+		log.Printf("Library of id: %s not found", id)
+		notFound(w, id)
+		return
+	}
+
+	index, err := db.Open(lib.IndexPath, lib.Storage)
 	if err != nil {
 		log.Printf("Error opening index: %v", err)
 		internalServerError(w)
@@ -103,12 +143,13 @@ func (h *DownloadHandler) DownloadConverted(w http.ResponseWriter, r *http.Reque
 	}
 	defer index.Close()
 
-	book, err := index.GetBookById(id)
+	book, err := index.GetBookById(bookId)
 	if err != nil {
 		log.Printf("File with id: %s not found in index: %v", id, err)
 		notFound(w, id)
 		return
 	}
+	book.Library = lib.LibraryId
 
 	if strings.ToLower(book.File.Ext) != converter.From {
 		log.Printf("Wrong converter selected for id: %s. expected %s=>%s, got %s=>%s", id, book.File.Ext, ext, converter.From, converter.To)
@@ -116,41 +157,28 @@ func (h *DownloadHandler) DownloadConverted(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	var filename string
-	if book.File.IsArchived() {
-		data, err := h.getFileFromArchive(book)
-		if err != nil {
-			notFound(w, id)
-			return
-		}
-
-		f, err := os.CreateTemp("", "book*."+book.File.Ext)
-		if err != nil {
-			log.Printf("Error creating temp file: %v", err)
-			notFound(w, id)
-			return
-		}
-
-		if _, err := f.Write(data); err != nil {
-			log.Printf("Error writing to temp file: %v", err)
-			notFound(w, id)
-			return
-		}
+	file, err := h.openBook(lib, book)
+	if err != nil {
+		notFound(w, id)
+		return
+	}
+	defer file.Close()
 
-		if err := f.Close(); err != nil {
-			log.Printf("Error closing temp file: %v", err)
-			notFound(w, id)
-			return
-		}
+	// Converters aren't guaranteed to produce identical bytes, so weak validator is used.
+	rewrite := h.shouldRewriteMetadata(r, file.Name)
+	etag, modTime := fileValidators(index, book, file.ModTime, file.Size, converter.To, rewrite)
+	if checkNotModified(w, r, "W/"+etag, modTime) {
+		return
+	}
 
-		filename = f.Name()
-		defer os.Remove(filename)
-	} else {
-		filename, err = h.getDirectFilePath(book)
+	filename := file.Path
+	if filename == "" || rewrite {
+		filename, err = writeTempFile(book, file.Name, file.Reader, file.Size, rewrite)
 		if err != nil {
 			notFound(w, id)
 			return
 		}
+		defer os.Remove(filename)
 	}
 
 	outDir := os.TempDir()
@@ -173,78 +201,205 @@ func (h *DownloadHandler) DownloadConverted(w http.ResponseWriter, r *http.Reque
 	}
 	defer os.Remove(outFilename)
 
+	out, err := os.Open(outFilename)
+	if err != nil {
+		log.Printf("Error opening converted file: %v", err)
+		notFound(w, id)
+		return
+	}
+	defer out.Close()
+
 	log.Printf("Serving converted file from: %s", outFilename)
-	addFilenameToHeader(w, book.Title, book.LibId+"."+converter.To)
-	http.ServeFile(w, r, outFilename)
+	h.addFilenameToHeader(w, book, book.LibId+"."+converter.To)
+	http.ServeContent(w, r, outFilename, modTime, out)
 }
 
-func (h *DownloadHandler) getDirectFilePath(book *model.Book) (string, error) {
-	filename := filepath.Join(h.cfg.LibraryPath, filepath.FromSlash(book.File.Folder), book.File.Name)
-	if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
-		log.Printf("File `%s` (id: %s) not found: %v", filename, book.LibId, err)
-		return "", err
+// DownloadZipped serves FB2 book packed into zip archive on the fly.
+func (h *DownloadHandler) DownloadZipped(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	lib, bookId := findLibrary(h.libraries, id)
+	if lib == nil {
+		log.Printf("Library of id: %s not found", id)
+		notFound(w, id)
+		return
 	}
 
-	return filename, nil
-}
+	index, err := db.Open(lib.IndexPath, lib.Storage)
+	if err != nil {
+		log.Printf("Error opening index: %v", err)
+		internalServerError(w)
+		return
+	}
+	defer index.Close()
 
-func (h *DownloadHandler) getFileFromArchive(book *model.Book) ([]byte, error) {
-	archivePath := filepath.Join(h.cfg.LibraryPath, book.File.ArchivePath())
-	zf, err := zip.OpenReader(archivePath)
+	book, err := index.GetBookById(bookId)
 	if err != nil {
-		log.Printf("Can't open archive `%s` (id: %s) not found: %v", archivePath, book.LibId, err)
-		return nil, err
+		log.Printf("File with id: %s not found in index: %v", id, err)
+		notFound(w, id)
+		return
 	}
-	defer zf.Close()
+	book.Library = lib.LibraryId
 
-	bookName := fmt.Sprintf("%s.%s", book.File.Name, book.File.Ext)
-	for _, file := range zf.File {
-		if file.Name == bookName {
-			content, err := file.Open()
-			if err != nil {
-				log.Printf("Can't open file `%s` in archive `%s` (id: %s) not found: %v", bookName, archivePath, book.LibId, err)
-				return nil, err
-			}
-
-			data, err := io.ReadAll(content)
-			if err != nil {
-				log.Printf("Can't read file `%s` in archive `%s` (id: %s) not found: %v", bookName, archivePath, book.LibId, err)
-				content.Close()
-				return nil, err
-			}
-			content.Close()
-
-			return data, nil
+	if !canZip(book) {
+		log.Printf("File with id: %s can't be served as %s", id, zippedFb2Ext)
+		notFound(w, id)
+		return
+	}
+
+	file, err := h.openBook(lib, book)
+	if err != nil {
+		notFound(w, id)
+		return
+	}
+	defer file.Close()
+
+	log.Printf("File `%s` for id %s served as %s", file.Name, id, zippedFb2Ext)
+
+	// Zip is created on the fly and its bytes differ between requests, so weak validator is used.
+	rewrite := h.shouldRewriteMetadata(r, file.Name)
+	etag, modTime := fileValidators(index, book, file.ModTime, file.Size, zippedFb2Ext, rewrite)
+	if checkNotModified(w, r, "W/"+etag, modTime) {
+		return
+	}
+
+	h.addFilenameToHeader(w, book, file.Name+".zip")
+	w.Header().Set("Content-Type", mime.TypeByExtension("."+zippedFb2Ext))
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	name := h.downloadName(book, file.Name)
+	zw := zip.NewWriter(w)
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     sanitizeFilename(strings.TrimSuffix(name, ".fb2")) + ".fb2",
+		Method:   zip.Deflate,
+		Modified: modTime,
+	})
+	if err != nil {
+		log.Printf("Error creating zip for id %s: %v", id, err)
+		return
+	}
+
+	if rewrite {
+		err = writeWithMetadata(fw, book, file.Name, file.Reader, file.Size)
+	} else {
+		_, err = io.CopyBuffer(fw, file, make([]byte, streamBufferSize))
+	}
+	if err != nil {
+		log.Printf("Error writing zip for id %s: %v", id, err)
+		return
+	}
+
+	if err := zw.Close(); err != nil {
+		log.Printf("Error writing zip for id %s: %v", id, err)
+	}
+}
+
+// bookFile is a book file opened for reading, either from archive or directly from file system.
+type bookFile struct {
+	io.Reader
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// Path is set for files stored on local disk outside of archives.
+	Path   string
+	closer io.Closer
+}
+
+func (f *bookFile) Close() error {
+	return f.closer.Close()
+}
+
+// openBook opens file of book from library.
+func (h *DownloadHandler) openBook(lib *config.MyConfig, book *model.Book) (*bookFile, error) {
+	if book.File.IsArchived() {
+		file, err := h.getFileFromArchive(lib, book)
+		if err != nil {
+			return nil, err
 		}
+
+		return &bookFile{
+			Reader:  file.Reader,
+			Name:    file.Name,
+			Size:    file.Size,
+			ModTime: file.ModTime,
+			closer:  file,
+		}, nil
+	}
+
+	f, err := h.getDirectFile(lib, book)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("File `%s` not found in archive `%s` (id: %s)", bookName, archivePath, book.LibId)
-	return nil, os.ErrNotExist
+	return &bookFile{
+		Reader:  f,
+		Name:    book.File.Name,
+		Size:    f.Size(),
+		ModTime: f.ModTime(),
+		Path:    filestore.LocalPath(f),
+		closer:  f,
+	}, nil
 }
 
-func addFilenameToHeader(w http.ResponseWriter, title string, filename string) {
-	fileNameTranslit := formatFileNameTranslit(title, filename)
-	fileNameUtf8 := formatFileNameUtf8(title, filename)
-	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%s; filename*=UTF-8''%s", fileNameTranslit, fileNameUtf8))
+func (h *DownloadHandler) getDirectFile(lib *config.MyConfig, book *model.Book) (filestore.File, error) {
+	name := path.Join(book.File.Folder, book.File.Name)
+	f, err := h.resolvers[lib.LibraryId].Store(name).Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("File `%s` (id: %s) not found: %v", name, book.LibId, err)
+		h.resolvers[lib.LibraryId].Forget(name)
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("Error opening file `%s`: %v", name, err)
+		return nil, err
+	}
+
+	return f, nil
 }
 
-func formatFileNameUtf8(title string, filename string) string {
-	fileName := fmt.Sprintf("%s-%s", url.PathEscape(title), filename)
-	return fileName
+func (h *DownloadHandler) getFileFromArchive(lib *config.MyConfig, book *model.Book) (*archive.File, error) {
+	archivePath := book.File.ArchivePath()
+	bookName := fmt.Sprintf("%s.%s", book.File.Name, book.File.Ext)
+	file, err := h.archives.Open(h.resolvers[lib.LibraryId].Store(archivePath), archivePath, bookName)
+	if errors.Is(err, archive.ErrNotFound) {
+		log.Printf("File `%s` not found in archive `%s` (id: %s)", bookName, archivePath, book.LibId)
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("Can't open file `%s` in archive `%s` (id: %s): %v", bookName, archivePath, book.LibId, err)
+		h.resolvers[lib.LibraryId].Forget(archivePath)
+		return nil, err
+	}
+
+	return file, nil
 }
 
-func formatFileNameTranslit(title string, filename string) string {
-	str := translit.ICAO(title)
+// serveBookFile writes file to response. Seekable files are served with range requests support,
+// others are streamed with a known length through a bounded buffer.
+func serveBookFile(w http.ResponseWriter, r *http.Request, file *bookFile) {
+	if rs, ok := file.Reader.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, file.Name, file.ModTime, rs)
+		return
+	}
 
-	replace := map[string]string{
-		" ":  "_",
-		"/":  "_",
-		"\\": "_",
+	contentType := mime.TypeByExtension(filepath.Ext(file.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
 	}
-	for s, r := range replace {
-		str = strings.ReplaceAll(str, s, r)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	w.Header().Set("Accept-Ranges", "none")
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
 	}
 
-	fileName := fmt.Sprintf("%s-%s", str, filename)
-	return fileName
+	if _, err := io.CopyBuffer(w, file, make([]byte, streamBufferSize)); err != nil {
+		log.Printf("Error streaming file `%s`: %v", file.Name, err)
+	}
 }
@@ -13,3 +13,8 @@ func notFound(w http.ResponseWriter, id string) {
 	msg := fmt.Sprintf("File with id %s not found", id)
 	http.Error(w, msg, http.StatusNotFound)
 }
+
+func libraryNotFound(w http.ResponseWriter, id string) {
+	msg := fmt.Sprintf("Library %s not found", id)
+	http.Error(w, msg, http.StatusNotFound)
+}
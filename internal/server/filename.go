@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/essentialkaos/translit/v2"
+
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+// maxFilenameLength limits length (in runes) of file name generated from template, without extension.
+const maxFilenameLength = 120
+
+var placeholderRe = regexp.MustCompile(`\{(\w+)}`)
+
+// addFilenameToHeader sets Content-Disposition for book being served.
+// filename is the name of actual file, see downloadName.
+func (h *DownloadHandler) addFilenameToHeader(w http.ResponseWriter, book *model.Book, filename string) {
+	name := h.downloadName(book, filename)
+	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=%s; filename*=UTF-8''%s", translitFilename(name), url.PathEscape(name)))
+}
+
+// downloadName returns name for downloaded book. filename is the name of actual file,
+// it's appended to title when no template configured and used for extension otherwise.
+func (h *DownloadHandler) downloadName(book *model.Book, filename string) string {
+	ext := fileExt(filename)
+	if h.cfg.FilenameTemplate == "" {
+		name := sanitizeFilename(fmt.Sprintf("%s-%s", book.Title, strings.TrimSuffix(filename, ext)))
+		if name == "" {
+			name = book.LibId
+		}
+		return name + ext
+	}
+
+	return formatFilename(h.cfg.FilenameTemplate, book, h.cfg.AuthorNameFormat) + ext
+}
+
+func translitFilename(name string) string {
+	str := translit.ICAO(name)
+
+	replace := map[string]string{
+		" ":  "_",
+		"/":  "_",
+		"\\": "_",
+		";":  "_",
+		"\"": "_",
+	}
+	for s, r := range replace {
+		str = strings.ReplaceAll(str, s, r)
+	}
+
+	return str
+}
+
+// formatFilename renders file name (without extension) for book using template.
+// Supported placeholders: {author}, {series}, {series_no}, {title}, {year}, {lang}, {id}.
+// Part of template enclosed in square brackets is omitted when any placeholder inside it is empty,
+// e.g. "{author} - [{series} {series_no} - ]{title}".
+func formatFilename(template string, book *model.Book, authorNameFormat string) string {
+	values := filenameValues(book, authorNameFormat)
+
+	var sb strings.Builder
+	for template != "" {
+		start := strings.IndexByte(template, '[')
+		end := strings.IndexByte(template, ']')
+		if start < 0 || end < start {
+			s, _ := expandPlaceholders(template, values)
+			sb.WriteString(s)
+			break
+		}
+
+		s, _ := expandPlaceholders(template[:start], values)
+		sb.WriteString(s)
+		if s, ok := expandPlaceholders(template[start+1:end], values); ok {
+			sb.WriteString(s)
+		}
+		template = template[end+1:]
+	}
+
+	name := sanitizeFilename(sb.String())
+	if name == "" {
+		return book.LibId
+	}
+
+	return name
+}
+
+func filenameValues(book *model.Book, authorNameFormat string) map[string]string {
+	values := map[string]string{
+		"author":    "",
+		"series":    book.Series,
+		"series_no": "",
+		"title":     strings.TrimSpace(book.CleanTitle()),
+		"year":      "",
+		"lang":      book.Language,
+		"id":        book.LibId,
+	}
+
+	if len(book.Authors) > 0 {
+		values["author"] = book.Authors[0].FormattedName(authorNameFormat)
+	}
+
+	if book.Series != "" && book.SeriesNo > 0 {
+		values["series_no"] = fmt.Sprintf("%02d", book.SeriesNo)
+	}
+
+	if !book.PubDate.IsZero() {
+		values["year"] = book.PubYear()
+	}
+
+	return values
+}
+
+// expandPlaceholders replaces known placeholders with values.
+// Returns false if any of them is empty.
+func expandPlaceholders(s string, values map[string]string) (string, bool) {
+	complete := true
+	res := placeholderRe.ReplaceAllStringFunc(s, func(m string) string {
+		v, ok := values[m[1:len(m)-1]]
+		if !ok {
+			return m
+		}
+		if v == "" {
+			complete = false
+		}
+		return v
+	})
+
+	return res, complete
+}
+
+// sanitizeFilename replaces characters not allowed in file names on common file systems
+// and limits name length.
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+
+	if runes := []rune(name); len(runes) > maxFilenameLength {
+		name = string(runes[:maxFilenameLength])
+	}
+
+	return strings.Trim(name, " .")
+}
+
+// fileExt returns extension of file name including compound ones like `.fb2.zip`.
+func fileExt(filename string) string {
+	ext := filepath.Ext(filename)
+	if strings.EqualFold(ext, ".zip") {
+		return filepath.Ext(strings.TrimSuffix(filename, ext)) + ext
+	}
+
+	return ext
+}
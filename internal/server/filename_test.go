@@ -0,0 +1,131 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+func TestFormatFilename(t *testing.T) {
+	book := &model.Book{
+		LibId:    "42",
+		Title:    "Солярис",
+		Authors:  []model.Author{{FirstName: "Станислав", LastName: "Лем"}},
+		Series:   "Космос",
+		SeriesNo: 3,
+		PubDate:  time.Date(1961, 1, 1, 0, 0, 0, 0, time.UTC),
+		Language: "ru",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		book     func(b *model.Book)
+		format   string
+		want     string
+	}{
+		{
+			name:     "all placeholders",
+			template: "{author} - [{series} {series_no} - ]{title} ({year}, {lang}, {id})",
+			want:     "Станислав Лем - Космос 03 - Солярис (1961, ru, 42)",
+		},
+		{
+			name:     "series number is padded",
+			template: "{series_no}",
+			book:     func(b *model.Book) { b.SeriesNo = 12 },
+			want:     "12",
+		},
+		{
+			name:     "empty optional section",
+			template: "{author} - [{series} {series_no} - ]{title}",
+			book:     func(b *model.Book) { b.Series, b.SeriesNo = "", 0 },
+			want:     "Станислав Лем - Солярис",
+		},
+		{
+			name:     "section without series number",
+			template: "[{series} {series_no} - ]{title}[ ({year})]",
+			book:     func(b *model.Book) { b.SeriesNo = 0; b.PubDate = time.Time{} },
+			want:     "Солярис",
+		},
+		{
+			name:     "author name format",
+			template: "{author}",
+			format:   "initials",
+			want:     "С. Лем",
+		},
+		{
+			name:     "unknown placeholder is kept",
+			template: "{title} {isbn}",
+			want:     "Солярис {isbn}",
+		},
+		{
+			name:     "illegal characters in title and author",
+			template: "{author} - {title}",
+			book: func(b *model.Book) {
+				b.Title = `What? "Why": a/b\c <d>|e*`
+				b.Authors = []model.Author{{LastName: "AC/DC"}}
+			},
+			want: "AC_DC - What_ _Why__ a_b_c _d__e_",
+		},
+		{
+			name:     "cleaned title, spaces and dots",
+			template: " {title} [ignored]...",
+			book:     func(b *model.Book) { b.Title = "Солярис [litres]   2" },
+			want:     "Солярис 2 ignored",
+		},
+		{
+			name:     "empty name falls back to id",
+			template: "[{series}]",
+			book:     func(b *model.Book) { b.Series = "" },
+			want:     "42",
+		},
+		{
+			name:     "long name is truncated",
+			template: "{title}",
+			book:     func(b *model.Book) { b.Title = strings.Repeat("Ж", 200) },
+			want:     strings.Repeat("Ж", maxFilenameLength),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := *book
+			if tt.book != nil {
+				tt.book(&b)
+			}
+			assert.Equal(t, tt.want, formatFilename(tt.template, &b, tt.format))
+		})
+	}
+}
+
+func TestDownloadName(t *testing.T) {
+	book := &model.Book{LibId: "42", Title: "Что? Где: Когда/Зачем"}
+
+	tests := []struct {
+		name     string
+		template string
+		filename string
+		want     string
+	}{
+		{"title and file name", "", "166370.fb2", "Что_ Где_ Когда_Зачем-166370.fb2"},
+		{"compound extension", "", "Book.fb2.zip", "Что_ Где_ Когда_Зачем-Book.fb2.zip"},
+		{"template", "{id} {title}", "Book.epub", "42 Что_ Где_ Когда_Зачем.epub"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &DownloadHandler{cfg: &config.MyConfig{FilenameTemplate: tt.template}}
+			assert.Equal(t, tt.want, h.downloadName(book, tt.filename))
+		})
+	}
+
+	// Extension is kept when long name is truncated.
+	h := &DownloadHandler{cfg: &config.MyConfig{}}
+	long := &model.Book{LibId: "42", Title: strings.Repeat("Ж", 200)}
+	assert.Equal(t, strings.Repeat("Ж", maxFilenameLength)+".fb2", h.downloadName(long, "1.fb2"))
+}
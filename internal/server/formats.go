@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+const zippedFb2Ext = "fb2.zip"
+
+// downloadFormat is an alternative format book can be downloaded in.
+type downloadFormat struct {
+	Name string
+	Type string
+	Href string
+}
+
+// alternativeFormats returns formats book is available in besides the original one.
+func alternativeFormats(cfg *config.MyConfig, book *model.Book) []downloadFormat {
+	var formats []downloadFormat
+	if canZip(book) {
+		formats = append(formats, downloadFormat{
+			Name: zippedFb2Ext,
+			Type: mime.TypeByExtension("." + zippedFb2Ext),
+			Href: fmt.Sprintf("/download/%s/%s", book.Id(), zippedFb2Ext),
+		})
+	}
+
+	for _, converter := range cfg.Converters {
+		if strings.EqualFold(converter.From, book.File.Ext) {
+			formats = append(formats, downloadFormat{
+				Name: converter.To,
+				Type: mime.TypeByExtension("." + converter.To),
+				Href: fmt.Sprintf("/download/%s/%s", book.Id(), converter.To),
+			})
+		}
+	}
+
+	return formats
+}
+
+// canZip reports whether book can be served as `.fb2.zip`.
+func canZip(book *model.Book) bool {
+	if !strings.EqualFold(book.File.Ext, "fb2") {
+		return false
+	}
+
+	// Files stored outside of archives might be zipped already.
+	return book.File.IsArchived() || strings.EqualFold(fileExt(book.File.Name), ".fb2")
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/db"
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+// findLibrary returns configuration of library book with given id belongs to and id of book in it.
+// Ids without library prefix belong to the first library.
+func findLibrary(libraries []*config.MyConfig, id string) (*config.MyConfig, string) {
+	libraryId, bookId, found := strings.Cut(id, model.LibraryIdSeparator)
+	if !found {
+		return libraries[0], id
+	}
+
+	for _, lib := range libraries {
+		if lib.LibraryId == libraryId {
+			return lib, bookId
+		}
+	}
+	return nil, id
+}
+
+// selectLibraries returns libraries requested with `library` query parameter, all of them if it isn't set.
+func selectLibraries(libraries []*config.MyConfig, r *http.Request) ([]*config.MyConfig, bool) {
+	id := r.URL.Query().Get("library")
+	if id == "" {
+		return libraries, true
+	}
+
+	for _, lib := range libraries {
+		if lib.LibraryId == id {
+			return []*config.MyConfig{lib}, true
+		}
+	}
+	return nil, false
+}
+
+// libraryIndexes are indexes of libraries searched together.
+type libraryIndexes struct {
+	libraries []*config.MyConfig
+	indexes   []*db.Store
+}
+
+func openLibraries(libraries []*config.MyConfig) (*libraryIndexes, error) {
+	res := &libraryIndexes{libraries: libraries}
+	for _, lib := range libraries {
+		index, err := db.Open(lib.IndexPath, lib.Storage)
+		if err != nil {
+			res.Close()
+			return nil, err
+		}
+		res.indexes = append(res.indexes, index)
+	}
+
+	return res, nil
+}
+
+func (l *libraryIndexes) Close() error {
+	for _, index := range l.indexes {
+		index.Close()
+	}
+	return nil
+}
+
+// ModTime returns the latest modification time of indexes.
+func (l *libraryIndexes) ModTime() (time.Time, error) {
+	var res time.Time
+	for _, index := range l.indexes {
+		modTime, err := index.ModTime()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if modTime.After(res) {
+			res = modTime
+		}
+	}
+	return res, nil
+}
+
+// Info returns information about collection when single library is selected.
+func (l *libraryIndexes) Info() (*db.IndexInfo, error) {
+	if len(l.indexes) != 1 {
+		return nil, nil
+	}
+	return l.indexes[0].Info()
+}
+
+// SearchByField searches libraries in order of configuration, hits of each library follow hits of the previous one.
+func (l *libraryIndexes) SearchByField(field, query string, page, pageSize int) (*db.SearchResult, error) {
+	res := &db.SearchResult{}
+	from, size := page*pageSize, pageSize
+	for i, index := range l.indexes {
+		top, err := index.Search(field, query, from, size)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Total += top.Total
+		res.Hits = append(res.Hits, l.setLibrary(i, top.Hits)...)
+		size -= len(top.Hits)
+		from = max(0, from-int(top.Total))
+	}
+
+	return res, nil
+}
+
+// GetMostRecentBooks returns the most recent books of all libraries.
+func (l *libraryIndexes) GetMostRecentBooks(count int) ([]*model.Book, error) {
+	var books []*model.Book
+	for i, index := range l.indexes {
+		recent, err := index.GetMostRecentBooks(count)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, l.setLibrary(i, recent)...)
+	}
+
+	slices.SortStableFunc(books, func(a, b *model.Book) int {
+		return b.PubDate.Compare(a.PubDate)
+	})
+	return books[:min(count, len(books))], nil
+}
+
+func (l *libraryIndexes) setLibrary(i int, books []*model.Book) []*model.Book {
+	for _, book := range books {
+		book.Library = l.libraries[i].LibraryId
+	}
+	return books
+}
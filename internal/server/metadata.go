@@ -0,0 +1,101 @@
+package server
+
+import (
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/shemanaev/inpxer/internal/ebook"
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+// shouldRewriteMetadata reports whether metadata embedded in file should be replaced with catalog values.
+// Original file can always be requested with `original` query parameter.
+func (h *DownloadHandler) shouldRewriteMetadata(r *http.Request, filename string) bool {
+	return h.cfg.RewriteMetadata && !r.URL.Query().Has("original") && canRewriteMetadata(filename)
+}
+
+func canRewriteMetadata(filename string) bool {
+	switch strings.ToLower(fileExt(filename)) {
+	case ".fb2", ".epub":
+		return true
+	default:
+		return false
+	}
+}
+
+// serveWithMetadata streams book file with metadata rewritten. Size of result is unknown beforehand,
+// so range requests are not supported.
+func serveWithMetadata(w http.ResponseWriter, r *http.Request, book *model.Book, filename string, src io.Reader, size int64) {
+	w.Header().Set("Content-Type", mime.TypeByExtension(fileExt(filename)))
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if err := writeWithMetadata(w, book, filename, src, size); err != nil {
+		log.Printf("Error rewriting metadata of `%s` (id: %s): %v", filename, book.LibId, err)
+	}
+}
+
+// writeWithMetadata copies book file from src to w replacing embedded metadata with catalog values.
+func writeWithMetadata(w io.Writer, book *model.Book, filename string, src io.Reader, size int64) error {
+	meta := ebook.NewMetadata(book)
+
+	if strings.ToLower(fileExt(filename)) != ".epub" {
+		return ebook.RewriteFB2(w, src, meta)
+	}
+
+	// EPUB is a zip archive and requires random access.
+	if ra, ok := src.(io.ReaderAt); ok {
+		return ebook.RewriteEPUB(w, ra, size, meta)
+	}
+
+	f, err := os.CreateTemp("", "book*.epub")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err = io.CopyBuffer(f, src, make([]byte, streamBufferSize))
+	if err != nil {
+		return err
+	}
+
+	return ebook.RewriteEPUB(w, f, size, meta)
+}
+
+// writeTempFile copies book file into temporary file, rewriting metadata if rewrite is set.
+// Returns name of created file, which must be removed by caller.
+func writeTempFile(book *model.Book, filename string, src io.Reader, size int64, rewrite bool) (string, error) {
+	f, err := os.CreateTemp("", "book*"+fileExt(filename))
+	if err != nil {
+		log.Printf("Error creating temp file: %v", err)
+		return "", err
+	}
+
+	if rewrite {
+		err = writeWithMetadata(f, book, filename, src, size)
+	} else {
+		_, err = io.CopyBuffer(f, src, make([]byte, streamBufferSize))
+	}
+	if err != nil {
+		log.Printf("Error writing to temp file: %v", err)
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		log.Printf("Error closing temp file: %v", err)
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
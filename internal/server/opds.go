@@ -16,21 +16,22 @@ import (
 	"github.com/vorlif/spreak"
 
 	"github.com/shemanaev/inpxer/internal/config"
-	"github.com/shemanaev/inpxer/internal/db"
 	"github.com/shemanaev/inpxer/internal/i18n"
 	"github.com/shemanaev/inpxer/internal/model"
 	"github.com/shemanaev/inpxer/pkg/opds"
 )
 
 type OpdsHandler struct {
-	cfg *config.MyConfig
-	t   *spreak.Localizer
+	cfg       *config.MyConfig
+	libraries []*config.MyConfig
+	t         *spreak.Localizer
 }
 
 func NewOpdsHandler(cfg *config.MyConfig, localizer *spreak.Localizer) *OpdsHandler {
 	return &OpdsHandler{
-		cfg: cfg,
-		t:   localizer,
+		cfg:       cfg,
+		libraries: cfg.AllLibraries(),
+		t:         localizer,
 	}
 }
 
@@ -42,13 +43,29 @@ func (h *OpdsHandler) OpenSearchDescription(w http.ResponseWriter, r *http.Reque
 
 	description := opds.NewOpenSearchDescription(h.cfg.Title, templateUrl+"?q={searchTerms}&page={startPage?}")
 
+	modTime, notModified := checkPageNotModified(w, r, BuildDate)
+	if notModified {
+		return
+	}
+
 	content, _ := xml.MarshalIndent(description, "  ", "    ")
 	w.Header().Add("Content-Type", opds.ContentType)
-	http.ServeContent(w, r, "opensearch.xml", time.Now(), bytes.NewReader(content))
+	http.ServeContent(w, r, "opensearch.xml", modTime, bytes.NewReader(content))
 }
 
 func (h *OpdsHandler) Root(w http.ResponseWriter, r *http.Request) {
-	index, err := db.Open(h.cfg.IndexPath, h.cfg.Storage)
+	libraries, ok := selectLibraries(h.libraries, r)
+	if !ok {
+		libraryNotFound(w, r.URL.Query().Get("library"))
+		return
+	}
+
+	if len(libraries) > 1 {
+		h.serveLibraries(w, r)
+		return
+	}
+
+	index, err := openLibraries(libraries)
 	if err != nil {
 		log.Printf("Error opening index: %v", err)
 		internalServerError(w)
@@ -56,6 +73,11 @@ func (h *OpdsHandler) Root(w http.ResponseWriter, r *http.Request) {
 	}
 	defer index.Close()
 
+	modTime, notModified := checkIndexNotModified(w, r, index)
+	if notModified {
+		return
+	}
+
 	books, err := index.GetMostRecentBooks(PageSize)
 	if err != nil {
 		log.Printf("Error retrieving recent books: %v", err)
@@ -66,7 +88,7 @@ func (h *OpdsHandler) Root(w http.ResponseWriter, r *http.Request) {
 
 	entries := h.makeBooksList(books)
 
-	h.serveFeed(w, r, "root", entries, nil, uint64(len(books)))
+	h.serveFeed(w, r, index, "root", entries, nil, uint64(len(books)), modTime)
 }
 
 func (h *OpdsHandler) Search(w http.ResponseWriter, r *http.Request) {
@@ -81,7 +103,13 @@ func (h *OpdsHandler) Search(w http.ResponseWriter, r *http.Request) {
 		page = 0
 	}
 
-	index, err := db.Open(h.cfg.IndexPath, h.cfg.Storage)
+	libraries, ok := selectLibraries(h.libraries, r)
+	if !ok {
+		libraryNotFound(w, r.URL.Query().Get("library"))
+		return
+	}
+
+	index, err := openLibraries(libraries)
 	if err != nil {
 		log.Printf("Error opening index: %v", err)
 		internalServerError(w)
@@ -89,6 +117,11 @@ func (h *OpdsHandler) Search(w http.ResponseWriter, r *http.Request) {
 	}
 	defer index.Close()
 
+	modTime, notModified := checkIndexNotModified(w, r, index)
+	if notModified {
+		return
+	}
+
 	top, err := index.SearchByField(field, q, page, PageSize)
 	if err != nil {
 		log.Printf("Error searching: %v", err)
@@ -101,7 +134,7 @@ func (h *OpdsHandler) Search(w http.ResponseWriter, r *http.Request) {
 	totalPages := int(math.Ceil(float64(top.Total) / float64(PageSize)))
 
 	var links []opds.Link
-	link := fmt.Sprintf("/opds/search?q=%s", url.QueryEscape(q))
+	link := fmt.Sprintf("/opds/search?q=%s%s", url.QueryEscape(q), libraryQuery(r))
 	links = append(links, opds.Link{
 		Rel:  opds.LinkRelFirst,
 		Type: opds.LinkTypeNavigation,
@@ -109,7 +142,7 @@ func (h *OpdsHandler) Search(w http.ResponseWriter, r *http.Request) {
 	})
 
 	if page > 0 {
-		link := fmt.Sprintf("/opds/search?q=%s&page=%d", url.QueryEscape(q), page-1)
+		link := fmt.Sprintf("/opds/search?q=%s%s&page=%d", url.QueryEscape(q), libraryQuery(r), page-1)
 		links = append(links, opds.Link{
 			Rel:  opds.LinkRelPrev,
 			Type: opds.LinkTypeNavigation,
@@ -118,7 +151,7 @@ func (h *OpdsHandler) Search(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if page+1 <= totalPages-1 {
-		link := fmt.Sprintf("/opds/search?q=%s&page=%d", url.QueryEscape(q), page+1)
+		link := fmt.Sprintf("/opds/search?q=%s%s&page=%d", url.QueryEscape(q), libraryQuery(r), page+1)
 		links = append(links, opds.Link{
 			Rel:  opds.LinkRelNext,
 			Type: opds.LinkTypeNavigation,
@@ -127,7 +160,7 @@ func (h *OpdsHandler) Search(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if totalPages > 1 && page != totalPages-1 {
-		link := fmt.Sprintf("/opds/search?q=%s&page=%d", url.QueryEscape(q), totalPages-1)
+		link := fmt.Sprintf("/opds/search?q=%s%s&page=%d", url.QueryEscape(q), libraryQuery(r), totalPages-1)
 		links = append(links, opds.Link{
 			Rel:  opds.LinkRelLast,
 			Type: opds.LinkTypeNavigation,
@@ -135,15 +168,59 @@ func (h *OpdsHandler) Search(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	h.serveFeed(w, r, "search", entries, links, top.Total)
+	h.serveFeed(w, r, index, "search", entries, links, top.Total, modTime)
+}
+
+// serveLibraries serves navigation feed with every library.
+func (h *OpdsHandler) serveLibraries(w http.ResponseWriter, r *http.Request) {
+	modTime, notModified := checkPageNotModified(w, r, BuildDate)
+	if notModified {
+		return
+	}
+
+	var entries []*opds.Entry
+	for _, lib := range h.libraries {
+		entries = append(entries, &opds.Entry{
+			ID:      "library:" + lib.LibraryId,
+			Title:   lib.Title,
+			Updated: &modTime,
+			Link: []opds.Link{{
+				Rel:  opds.LinkRelSubsection,
+				Type: opds.LinkTypeAcquisition,
+				Href: "/opds?library=" + url.QueryEscape(lib.LibraryId),
+			}},
+		})
+	}
+
+	h.serveFeed(w, r, &libraryIndexes{}, "libraries", entries, nil, uint64(len(entries)), modTime)
 }
 
-func (h *OpdsHandler) serveFeed(w http.ResponseWriter, r *http.Request, id string, entries []*opds.Entry, links []opds.Link, totalResults uint64) {
-	now := time.Now()
+// libraryQuery returns `library` parameter of request to be appended to links.
+func libraryQuery(r *http.Request) string {
+	if library := r.URL.Query().Get("library"); library != "" {
+		return "&library=" + url.QueryEscape(library)
+	}
+	return ""
+}
+
+func (h *OpdsHandler) serveFeed(w http.ResponseWriter, r *http.Request, index *libraryIndexes, id string, entries []*opds.Entry, links []opds.Link, totalResults uint64, modTime time.Time) {
 	feed := opds.NewFeed()
 	feed.ID = id
 	feed.Title = h.cfg.Title
-	feed.Updated = &now
+	feed.Updated = &modTime
+	if len(index.libraries) == 1 {
+		feed.Title = index.libraries[0].Title
+	}
+
+	if info, err := index.Info(); err != nil {
+		log.Printf("Error reading collection info: %v", err)
+	} else if info != nil {
+		feed.Updated = &info.ImportedAt
+		feed.Author = &opds.Author{
+			Name: info.Name,
+			Uri:  h.cfg.FullUrl,
+		}
+	}
 	feed.Entry = entries
 	feed.ItemsPerPage = PageSize
 	feed.TotalResults = totalResults
@@ -162,7 +239,7 @@ func (h *OpdsHandler) serveFeed(w http.ResponseWriter, r *http.Request, id strin
 		{
 			Rel:  opds.LinkRelSearch,
 			Type: opds.ContentType,
-			Href: "/opds/search?q={searchTerms}",
+			Href: "/opds/search?q={searchTerms}" + libraryQuery(r),
 		},
 	}
 
@@ -172,26 +249,43 @@ func (h *OpdsHandler) serveFeed(w http.ResponseWriter, r *http.Request, id strin
 	content, _ := xml.MarshalIndent(feed, "  ", "    ")
 	w.Header().Add("Content-Type", opds.ContentType)
 	content = append([]byte(xml.Header), content...)
-	http.ServeContent(w, r, "feed.xml", time.Now(), bytes.NewReader(content))
+	http.ServeContent(w, r, "feed.xml", modTime, bytes.NewReader(content))
+}
+
+// libraryParam returns `library` parameter for links to books of the same library.
+func libraryParam(book *model.Book) string {
+	if book.Library == "" {
+		return ""
+	}
+	return "&library=" + url.QueryEscape(book.Library)
 }
 
 func (h *OpdsHandler) makeBooksList(books []*model.Book) []*opds.Entry {
 	entries := make([]*opds.Entry, 0)
 	for _, book := range books {
 		entry := &opds.Entry{
-			ID:       fmt.Sprintf("book:%s", book.LibId),
+			ID:       fmt.Sprintf("book:%s", book.Id()),
 			Title:    book.CleanTitle(),
 			Issued:   &book.PubDate,
 			Language: book.Language,
 		}
-		entry.Content = opds.NewText(h.t.Getf("Original title: %s", book.Title))
+		content := []string{h.t.Getf("Original title: %s", book.Title)}
+		if book.Broken {
+			content = append(content, h.t.Get("File is missing or damaged"))
+		}
+		for _, field := range h.cfg.ExtraFields {
+			if value := book.Extra[field.Name]; value != "" {
+				content = append(content, fmt.Sprintf("%s: %s", field.Label(), value))
+			}
+		}
+		entry.Content = opds.NewText(strings.Join(content, "\n"))
 
 		for _, author := range book.Authors {
 			entry.Author = append(entry.Author, opds.Author{Name: author.FormattedName(h.cfg.AuthorNameFormat)})
 			entry.Link = append(entry.Link, opds.Link{
 				Rel:   opds.LinkRelRelated,
 				Type:  opds.LinkTypeNavigation,
-				Href:  "/opds/search?q=" + url.QueryEscape(author.String()),
+				Href:  "/opds/search?q=" + url.QueryEscape(author.String()) + libraryParam(book),
 				Title: h.t.Getf("Search books by %s", author.FormattedName(h.cfg.AuthorNameFormat)),
 			})
 		}
@@ -214,17 +308,15 @@ func (h *OpdsHandler) makeBooksList(books []*model.Book) []*opds.Entry {
 		entry.Link = append(entry.Link, opds.Link{
 			Rel:  opds.LinkRelAcquisition,
 			Type: fileMime,
-			Href: fmt.Sprintf("/download/%s", book.LibId),
+			Href: fmt.Sprintf("/download/%s", book.Id()),
 		})
 
-		for _, converter := range h.cfg.Converters {
-			if strings.EqualFold(converter.From, book.File.Ext) {
-				entry.Link = append(entry.Link, opds.Link{
-					Rel:  opds.LinkRelAcquisition,
-					Type: mime.TypeByExtension("." + converter.To),
-					Href: fmt.Sprintf("/download/%s/%s", book.LibId, converter.To),
-				})
-			}
+		for _, format := range alternativeFormats(h.cfg, book) {
+			entry.Link = append(entry.Link, opds.Link{
+				Rel:  opds.LinkRelAcquisition,
+				Type: format.Type,
+				Href: format.Href,
+			})
 		}
 
 		entries = append(entries, entry)
@@ -9,6 +9,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/urfave/cli/v2"
 
+	"github.com/shemanaev/inpxer/internal/archive"
 	"github.com/shemanaev/inpxer/internal/config"
 	"github.com/shemanaev/inpxer/internal/i18n"
 	"github.com/shemanaev/inpxer/ui"
@@ -16,6 +17,9 @@ import (
 
 const PageSize = 10
 
+// archiveCacheSize is a number of library archives kept open between downloads.
+const archiveCacheSize = 32
+
 var BuildDate = time.Now()
 
 func init() {
@@ -32,16 +36,32 @@ func init() {
 }
 
 func Run(cfg *config.MyConfig, isDevMode bool, version string) error {
+	archives := archive.NewCache(archiveCacheSize)
+	defer archives.Close()
+
+	r, err := newRouter(cfg, isDevMode, version, archives)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	err = http.ListenAndServe(cfg.Listen, r)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	return nil
+}
+
+func newRouter(cfg *config.MyConfig, isDevMode bool, version string, archives *archive.Cache) (http.Handler, error) {
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.CleanPath)
 	r.Use(middleware.StripSlashes)
-	r.Use(middleware.Compress(5, "application/fb2"))
 	r.Use(middleware.SetHeader("Server", "inpxer/"+version))
 
 	t, err := i18n.GetLocalizer(cfg.Language)
 	if err != nil {
-		return cli.Exit(err.Error(), 1)
+		return nil, err
 	}
 
 	fs := http.FileServer(
@@ -59,10 +79,15 @@ func Run(cfg *config.MyConfig, isDevMode bool, version string) error {
 	web := NewWebHandler(cfg, t)
 	r.Get("/", web.Home)
 	r.Get("/search", web.Search)
+	r.Get("/about", web.About)
 
-	download := NewDownloadHandler(cfg)
+	download, err := NewDownloadHandler(cfg, archives)
+	if err != nil {
+		return nil, err
+	}
 	r.Route("/download", func(r chi.Router) {
 		r.Get("/{id}", download.Download)
+		r.Get("/{id}/"+zippedFb2Ext, download.DownloadZipped)
 		r.Get("/{id}/{ext}", download.DownloadConverted)
 	})
 
@@ -73,10 +98,5 @@ func Run(cfg *config.MyConfig, isDevMode bool, version string) error {
 		r.Get("/search", opds.Search)
 	})
 
-	err = http.ListenAndServe(cfg.Listen, r)
-	if err != nil {
-		return cli.Exit(err.Error(), 1)
-	}
-
-	return nil
+	return r, nil
 }
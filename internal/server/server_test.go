@@ -0,0 +1,599 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/archive"
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/db"
+	"github.com/shemanaev/inpxer/internal/filestore/s3test"
+	"github.com/shemanaev/inpxer/internal/model"
+	"github.com/shemanaev/inpxer/pkg/inpx"
+)
+
+const (
+	testInpx    = "../../pkg/inpx/testdata/flibusta_fb2_local.inpx"
+	testBookId  = "166370"
+	testArchive = "fb2-166043-168102.zip"
+	testContent = `<?xml version="1.0" encoding="utf-8"?><FictionBook><body>test</body></FictionBook>`
+	// testBook has description which is rewritten with catalog metadata.
+	testBook = `<?xml version="1.0" encoding="utf-8"?><FictionBook><description><title-info>` +
+		`<book-title>Original title</book-title></title-info></description><body>test</body></FictionBook>`
+)
+
+// newTestServer returns server over collection loaded into memory storage.
+// Library contains single book testBookId. Options are applied to config after library is created.
+func newTestServer(t *testing.T, options ...func(cfg *config.MyConfig)) (*httptest.Server, *model.Book) {
+	t.Helper()
+
+	libraryPath := t.TempDir()
+	createTestArchive(t, filepath.Join(libraryPath, testArchive))
+
+	cfg := &config.MyConfig{
+		Storage:     "memory",
+		Language:    "ru",
+		Title:       "Test",
+		IndexPath:   t.Name(),
+		LibraryPath: libraryPath,
+		FullUrl:     "http://localhost",
+		ExtraFields: []*config.ExtraField{{Name: "PUBLISHER", Title: "Издательство"}},
+	}
+	for _, option := range options {
+		option(cfg)
+	}
+
+	book := loadTestIndex(t, cfg)
+
+	archives := archive.NewCache(archiveCacheSize)
+	t.Cleanup(func() { archives.Close() })
+
+	r, err := newRouter(cfg, true, "test", archives)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+
+	return ts, book
+}
+
+// loadTestIndex loads test collection into memory storage of library and returns book testBookId.
+func loadTestIndex(t *testing.T, cfg *config.MyConfig) *model.Book {
+	t.Helper()
+
+	index, err := db.Create(cfg.IndexPath, cfg.Language, cfg.Storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	index.IndexExtraFields(cfg.ExtraFieldNames())
+
+	collection, err := inpx.Open(testInpx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collection.Close()
+
+	var books []*model.Book
+	for book := range collection.Stream() {
+		b := model.NewBook(book)
+		if b.LibId == testBookId {
+			b.Extra = map[string]string{"PUBLISHER": "Эксмо"}
+		}
+		books = append(books, b)
+	}
+	if err := collection.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.AddBooks(books, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.SetInfo(&db.IndexInfo{Name: collection.Name, ImportedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	book, err := index.GetBookById(testBookId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return book
+}
+
+func createTestArchive(t *testing.T, name string) {
+	writeTestArchive(t, name, zip.Deflate, testContent)
+}
+
+// writeTestArchive writes archive with single book testBookId compressed by method.
+func writeTestArchive(t *testing.T, name string, method uint16, content string) {
+	t.Helper()
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: testBookId + ".fb2", Method: method})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(t *testing.T, u string, header http.Header) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, string(body)
+}
+
+func TestWebSearch(t *testing.T) {
+	ts, book := newTestServer(t)
+
+	resp, body := get(t, ts.URL+"/search?q="+url.QueryEscape(book.Title), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "/download/"+testBookId)
+}
+
+func TestOpdsSearch(t *testing.T) {
+	ts, book := newTestServer(t)
+
+	resp, body := get(t, ts.URL+"/opds/search?q="+url.QueryEscape(book.Title), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "application/atom+xml")
+	assert.Contains(t, body, "/download/"+testBookId)
+
+	resp, _ = get(t, ts.URL+"/opds/search?q="+url.QueryEscape(book.Title), http.Header{
+		"If-None-Match": {resp.Header.Get("ETag")},
+	})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+}
+
+func TestPageValidators(t *testing.T) {
+	ts, book := newTestServer(t)
+	index, err := db.Open(t.Name(), "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexTime, err := index.ModTime()
+	index.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages of index are as old as the index, not as the server process.
+	search := ts.URL + "/search?q=" + url.QueryEscape(book.Title)
+	resp, _ := get(t, search, nil)
+	assert.Equal(t, indexTime.UTC().Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+	etag := resp.Header.Get("ETag")
+
+	// Newer build changes templates.
+	buildDate := BuildDate
+	t.Cleanup(func() { BuildDate = buildDate })
+	BuildDate = indexTime.Add(time.Hour)
+
+	resp, _ = get(t, search, http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, BuildDate.UTC().Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+	resp, _ = get(t, ts.URL+"/", nil)
+	assert.Equal(t, BuildDate.UTC().Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+}
+
+func TestDownload(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	resp, body := get(t, ts.URL+"/download/"+testBookId, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, testContent, body)
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+
+	resp, body = get(t, ts.URL+"/download/"+testBookId, http.Header{
+		"If-None-Match": {resp.Header.Get("ETag")},
+	})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Empty(t, body)
+}
+
+// withArchive replaces test archive with one storing content of book by method.
+func withArchive(t *testing.T, method uint16, content string) func(cfg *config.MyConfig) {
+	return func(cfg *config.MyConfig) {
+		writeTestArchive(t, filepath.Join(cfg.LibraryPath, testArchive), method, content)
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	ts, _ := newTestServer(t, withArchive(t, zip.Store, testContent))
+
+	// Range of stored book is served as is, even when client accepts compressed response.
+	resp, body := get(t, ts.URL+"/download/"+testBookId, http.Header{
+		"Range":           {"bytes=5-9"},
+		"Accept-Encoding": {"gzip"},
+	})
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, testContent[5:10], body)
+	assert.Equal(t, fmt.Sprintf("bytes 5-9/%d", len(testContent)), resp.Header.Get("Content-Range"))
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+}
+
+func TestDownloadStreamed(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	// Compressed book is streamed without ranges support.
+	resp, body := get(t, ts.URL+"/download/"+testBookId, http.Header{
+		"Range":           {"bytes=5-9"},
+		"Accept-Encoding": {"gzip"},
+	})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, testContent, body)
+	assert.Equal(t, "none", resp.Header.Get("Accept-Ranges"))
+	assert.Equal(t, strconv.Itoa(len(testContent)), resp.Header.Get("Content-Length"))
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+}
+
+func TestDownloadConvertedOriginal(t *testing.T) {
+	if _, err := exec.LookPath("cp"); err != nil {
+		t.Skip("cp isn't available")
+	}
+	ts, book := newTestServer(t, withArchive(t, zip.Deflate, testBook), func(cfg *config.MyConfig) {
+		cfg.RewriteMetadata = true
+		cfg.Converters = []*config.Converter{{From: "fb2", To: "txt", Command: "cp", Arguments: "{from} {to}"}}
+	})
+
+	resp, body := get(t, ts.URL+"/download/"+testBookId+"/txt", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "<book-title>"+html.EscapeString(book.Title)+"</book-title>")
+	assert.NotContains(t, body, "Original title")
+
+	resp, body = get(t, ts.URL+"/download/"+testBookId+"/txt?original", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, testBook, body)
+}
+
+// readZip returns names and contents of files in archive.
+func readZip(t *testing.T, data string) map[string]string {
+	t.Helper()
+
+	zr, err := zip.NewReader(strings.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func TestDownloadZipped(t *testing.T) {
+	ts, book := newTestServer(t)
+	name := (&DownloadHandler{cfg: &config.MyConfig{}}).downloadName(book, testBookId+".fb2")
+
+	resp, body := get(t, ts.URL+"/download/"+testBookId+"/"+zippedFb2Ext, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/fb2+zip", resp.Header.Get("Content-Type"))
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), url.PathEscape(name+".zip"))
+	assert.Equal(t, map[string]string{name: testContent}, readZip(t, body))
+
+	resp, _ = get(t, ts.URL+"/download/"+testBookId+"/"+zippedFb2Ext, http.Header{
+		"If-None-Match": {resp.Header.Get("ETag")},
+	})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// Only FB2 books are zipped.
+	index, err := db.Open(t.Name(), "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	epub := &model.Book{LibId: "1", Title: "EPUB", File: model.File{Name: "1", Ext: "epub", Archive: "epub-1"}}
+	assert.NoError(t, index.AddBooks([]*model.Book{epub}, false))
+	index.Close()
+
+	resp, _ = get(t, ts.URL+"/download/1/"+zippedFb2Ext, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = get(t, ts.URL+"/download/166372/"+zippedFb2Ext, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestDownloadZippedWithMetadata(t *testing.T) {
+	ts, book := newTestServer(t, withArchive(t, zip.Store, testBook), func(cfg *config.MyConfig) {
+		cfg.RewriteMetadata = true
+	})
+	name := (&DownloadHandler{cfg: &config.MyConfig{}}).downloadName(book, testBookId+".fb2")
+
+	resp, body := get(t, ts.URL+"/download/"+testBookId+"/"+zippedFb2Ext, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	files := readZip(t, body)
+	if assert.Len(t, files, 1) {
+		assert.Contains(t, files[name], "<book-title>"+html.EscapeString(book.Title)+"</book-title>")
+		assert.NotContains(t, files[name], "Original title")
+	}
+
+	resp, body = get(t, ts.URL+"/download/"+testBookId+"/"+zippedFb2Ext+"?original", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]string{name: testBook}, readZip(t, body))
+}
+
+func TestDownloadNotFound(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	// Known to index, but missing in library.
+	resp, _ := get(t, ts.URL+"/download/166372", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = get(t, ts.URL+"/download/1", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestDownloadS3(t *testing.T) {
+	// Archive is much larger than the book, only its directory and the book should be requested.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "filler.bin", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filler := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(filler)
+	w.Write(filler)
+	w, err = zw.Create(testBookId + ".fb2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(testContent))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := s3test.NewServer(t)
+	storage.Put("books", "flibusta/"+testArchive, buf.Bytes())
+
+	cfg := &config.MyConfig{
+		Storage:     "memory",
+		Language:    "ru",
+		IndexPath:   t.Name(),
+		LibraryPath: "s3://books/flibusta",
+		S3:          *storage.Config(),
+	}
+	loadTestIndex(t, cfg)
+
+	archives := archive.NewCache(archiveCacheSize)
+	t.Cleanup(func() { archives.Close() })
+
+	r, err := newRouter(cfg, true, "test", archives)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+
+	resp, body := get(t, ts.URL+"/download/"+testBookId, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, testContent, body)
+	assert.Less(t, storage.Sent(), int64(buf.Len()/4))
+
+	resp, _ = get(t, ts.URL+"/download/166372", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAbout(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	resp, body := get(t, ts.URL+"/about", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "Flibusta FB2 Local")
+
+	_, body = get(t, ts.URL+"/opds", nil)
+	assert.Contains(t, body, "<name>Flibusta FB2 Local</name>")
+}
+
+func TestExtraFields(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	resp, body := get(t, ts.URL+"/search?field=Extra.PUBLISHER&q="+url.QueryEscape("эксмо"), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "/download/"+testBookId)
+	assert.Contains(t, body, "Издательство")
+
+	_, body = get(t, ts.URL+"/opds/search?q="+url.QueryEscape("эксмо"), nil)
+	assert.Contains(t, body, "/download/"+testBookId)
+	assert.Contains(t, body, "Издательство: Эксмо")
+}
+
+func TestBrokenBook(t *testing.T) {
+	ts, book := newTestServer(t)
+
+	_, body := get(t, ts.URL+"/search?q="+url.QueryEscape(book.Title), nil)
+	assert.NotContains(t, body, "Файл отсутствует или повреждён")
+
+	index, err := db.Open(t.Name(), "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Broken = true
+	err = index.AddBooks([]*model.Book{book}, false)
+	index.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, body = get(t, ts.URL+"/search?q="+url.QueryEscape(book.Title), nil)
+	assert.Contains(t, body, "Файл отсутствует или повреждён")
+
+	_, body = get(t, ts.URL+"/opds/search?q="+url.QueryEscape(book.Title), nil)
+	assert.Contains(t, body, "Файл отсутствует или повреждён")
+}
+
+func TestLibraries(t *testing.T) {
+	libraryPath := t.TempDir()
+	createTestArchive(t, filepath.Join(libraryPath, testArchive))
+
+	cfg := &config.MyConfig{
+		Storage:     "memory",
+		Language:    "ru",
+		Title:       "Test",
+		LibraryPath: libraryPath,
+		FullUrl:     "http://localhost",
+		Libraries: []*config.Library{
+			{Id: "flibusta", Title: "Флибуста", IndexPath: t.Name() + "/flibusta"},
+			{Id: "empty", Title: "Пустая", IndexPath: t.Name() + "/empty", LibraryPath: config.Paths{t.TempDir()}},
+		},
+	}
+	var book *model.Book
+	for _, lib := range cfg.AllLibraries() {
+		book = loadTestIndex(t, lib)
+	}
+
+	archives := archive.NewCache(archiveCacheSize)
+	t.Cleanup(func() { archives.Close() })
+
+	r, err := newRouter(cfg, true, "test", archives)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+
+	_, body := get(t, ts.URL+"/search?q="+url.QueryEscape(book.Title), nil)
+	assert.Contains(t, body, "/download/flibusta:"+testBookId)
+	assert.Contains(t, body, "/download/empty:"+testBookId)
+	assert.Contains(t, body, "Все библиотеки")
+
+	_, body = get(t, ts.URL+"/search?library=empty&q="+url.QueryEscape(book.Title), nil)
+	assert.NotContains(t, body, "/download/flibusta:"+testBookId)
+	assert.Contains(t, body, "/download/empty:"+testBookId)
+
+	resp, _ := get(t, ts.URL+"/search?library=missing&q=test", nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Each library is downloaded from its own folder.
+	resp, body = get(t, ts.URL+"/download/flibusta:"+testBookId, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, testContent, body)
+
+	resp, _ = get(t, ts.URL+"/download/empty:"+testBookId, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Ids without library belong to the first one.
+	resp, _ = get(t, ts.URL+"/download/"+testBookId, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = get(t, ts.URL+"/download/missing:"+testBookId, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	_, body = get(t, ts.URL+"/opds", nil)
+	assert.Contains(t, body, "/opds?library=flibusta")
+	assert.Contains(t, body, "<title>Пустая</title>")
+
+	_, body = get(t, ts.URL+"/opds?library=flibusta", nil)
+	assert.Contains(t, body, "<title>Флибуста</title>")
+	assert.Contains(t, body, "/opds/search?q={searchTerms}&amp;library=flibusta")
+
+	_, body = get(t, ts.URL+"/about", nil)
+	assert.Contains(t, body, "Флибуста")
+	assert.Contains(t, body, "Пустая")
+}
+
+func TestLibrariesSearchPages(t *testing.T) {
+	cfg := &config.MyConfig{
+		Storage:  "memory",
+		Language: "ru",
+		Libraries: []*config.Library{
+			{Id: "a", IndexPath: t.Name() + "/a"},
+			{Id: "b", IndexPath: t.Name() + "/b"},
+		},
+	}
+	libraries := cfg.AllLibraries()
+	for _, lib := range libraries {
+		loadTestIndex(t, lib)
+	}
+
+	index, err := openLibraries(libraries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	single, err := index.indexes[0].Search("_all", "Александр", 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := int(single.Total)
+	assert.Greater(t, total, 2)
+
+	// Pages of all libraries are the hits of the first one followed by hits of the second.
+	var ids []string
+	for page := 0; ; page++ {
+		top, err := index.SearchByField("_all", "Александр", page, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, uint64(2*total), top.Total)
+		if len(top.Hits) == 0 {
+			break
+		}
+		for _, book := range top.Hits {
+			ids = append(ids, book.Id())
+		}
+	}
+
+	var expected []string
+	for _, lib := range []string{"a", "b"} {
+		for _, book := range single.Hits {
+			expected = append(expected, lib+":"+book.LibId)
+		}
+	}
+	assert.Equal(t, expected, ids)
+}
@@ -18,9 +18,11 @@ import (
 
 type WebHandler struct {
 	cfg       *config.MyConfig
+	libraries []*config.MyConfig
 	localizer *spreak.Localizer
 	indexTpl  *template.Template
 	searchTpl *template.Template
+	aboutTpl  *template.Template
 }
 
 type pagination struct {
@@ -38,9 +40,18 @@ type resultStats struct {
 	RangeEnd   int
 }
 
+// collectionInfo describes collection of library on about page.
+type collectionInfo struct {
+	Title string
+	Info  *db.IndexInfo
+	Books int
+}
+
 type arguments struct {
 	T                *spreak.Localizer
-	Converters       []*config.Converter
+	Config           *config.MyConfig
+	Libraries        []*config.MyConfig
+	Library          string
 	TabTitle         string
 	Title            string
 	AuthorNameFormat string
@@ -49,6 +60,7 @@ type arguments struct {
 	Paginator        pagination
 	Results          resultStats
 	Hits             []*model.Book
+	Collections      []collectionInfo
 }
 
 func NewWebHandler(cfg *config.MyConfig, localizer *spreak.Localizer) *WebHandler {
@@ -62,19 +74,47 @@ func NewWebHandler(cfg *config.MyConfig, localizer *spreak.Localizer) *WebHandle
 		log.Fatal(err)
 	}
 
+	aboutTpl, err := template.ParseFS(ui.Templates, "templates/about.gohtml", "templates/_*.gohtml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	return &WebHandler{
 		cfg:       cfg,
+		libraries: cfg.AllLibraries(),
 		localizer: localizer,
 		indexTpl:  indexTpl,
 		searchTpl: searchTpl,
+		aboutTpl:  aboutTpl,
+	}
+}
+
+// Formats returns alternative download formats for book.
+func (a arguments) Formats(book *model.Book) []downloadFormat {
+	return alternativeFormats(a.Config, book)
+}
+
+// LibraryTitle returns title of library with id.
+func (a arguments) LibraryTitle(id string) string {
+	for _, lib := range a.Libraries {
+		if lib.LibraryId == id {
+			return lib.Title
+		}
 	}
+	return id
 }
 
 func (h *WebHandler) Home(w http.ResponseWriter, r *http.Request) {
+	if _, notModified := checkPageNotModified(w, r, BuildDate); notModified {
+		return
+	}
+
 	args := arguments{
-		T:        h.localizer,
-		TabTitle: h.cfg.Title,
-		Title:    h.cfg.Title,
+		T:         h.localizer,
+		Libraries: h.libraries,
+		Library:   r.URL.Query().Get("library"),
+		TabTitle:  h.cfg.Title,
+		Title:     h.cfg.Title,
 	}
 	if err := h.indexTpl.Execute(w, args); err != nil {
 		internalServerError(w)
@@ -89,7 +129,13 @@ func (h *WebHandler) Search(w http.ResponseWriter, r *http.Request) {
 		page = 0
 	}
 
-	index, err := db.Open(h.cfg.IndexPath, h.cfg.Storage)
+	libraries, ok := selectLibraries(h.libraries, r)
+	if !ok {
+		libraryNotFound(w, r.URL.Query().Get("library"))
+		return
+	}
+
+	index, err := openLibraries(libraries)
 	if err != nil {
 		log.Printf("Error opening index: %v", err)
 		internalServerError(w)
@@ -97,6 +143,10 @@ func (h *WebHandler) Search(w http.ResponseWriter, r *http.Request) {
 	}
 	defer index.Close()
 
+	if _, notModified := checkIndexNotModified(w, r, index); notModified {
+		return
+	}
+
 	top, err := index.SearchByField(field, q, page, PageSize)
 	if err != nil {
 		log.Printf("Error searching: %v", err.Error())
@@ -136,7 +186,9 @@ func (h *WebHandler) Search(w http.ResponseWriter, r *http.Request) {
 
 	args := arguments{
 		T:                h.localizer,
-		Converters:       h.cfg.Converters,
+		Config:           h.cfg,
+		Libraries:        h.libraries,
+		Library:          r.URL.Query().Get("library"),
 		TabTitle:         fmt.Sprintf("%s - %s", q, h.cfg.Title),
 		Title:            h.cfg.Title,
 		AuthorNameFormat: h.cfg.AuthorNameFormat,
@@ -150,3 +202,55 @@ func (h *WebHandler) Search(w http.ResponseWriter, r *http.Request) {
 		internalServerError(w)
 	}
 }
+
+func (h *WebHandler) About(w http.ResponseWriter, r *http.Request) {
+	libraries, ok := selectLibraries(h.libraries, r)
+	if !ok {
+		libraryNotFound(w, r.URL.Query().Get("library"))
+		return
+	}
+
+	index, err := openLibraries(libraries)
+	if err != nil {
+		log.Printf("Error opening index: %v", err)
+		internalServerError(w)
+		return
+	}
+	defer index.Close()
+
+	if _, notModified := checkIndexNotModified(w, r, index); notModified {
+		return
+	}
+
+	var collections []collectionInfo
+	for i, lib := range libraries {
+		info, err := index.indexes[i].Info()
+		if err != nil {
+			log.Printf("Error reading collection info: %v", err)
+			internalServerError(w)
+			return
+		}
+
+		count, err := index.indexes[i].Count()
+		if err != nil {
+			log.Printf("Error counting books: %v", err)
+			internalServerError(w)
+			return
+		}
+
+		collections = append(collections, collectionInfo{Title: lib.Title, Info: info, Books: count})
+	}
+
+	args := arguments{
+		T:           h.localizer,
+		Config:      h.cfg,
+		Libraries:   h.libraries,
+		Library:     r.URL.Query().Get("library"),
+		TabTitle:    fmt.Sprintf("%s - %s", h.localizer.Get("About collection"), h.cfg.Title),
+		Title:       h.cfg.Title,
+		Collections: collections,
+	}
+	if err := h.aboutTpl.Execute(w, args); err != nil {
+		internalServerError(w)
+	}
+}
@@ -0,0 +1,223 @@
+// Package verifier checks that files of stored books are present in library.
+package verifier
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+
+	"github.com/shemanaev/inpxer/internal/db"
+	"github.com/shemanaev/inpxer/internal/library"
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+// Reasons of broken records.
+const (
+	ReasonArchiveNotFound = "archive not found"
+	ReasonArchiveBroken   = "archive can't be read"
+	ReasonMemberNotFound  = "file not found in archive"
+	ReasonFileNotFound    = "file not found"
+	ReasonSizeMismatch    = "size mismatch"
+)
+
+// Problem describes broken record.
+type Problem struct {
+	LibId  string `json:"id"`
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+	// Expected and Actual sizes are set for size mismatch.
+	Expected int64 `json:"expected,omitempty"`
+	Actual   int64 `json:"actual,omitempty"`
+}
+
+func (p *Problem) String() string {
+	if p.Reason == ReasonSizeMismatch {
+		return fmt.Sprintf("%s %s: %s, expected %d, got %d", p.LibId, p.File, p.Reason, p.Expected, p.Actual)
+	}
+	return fmt.Sprintf("%s %s: %s", p.LibId, p.File, p.Reason)
+}
+
+// Group lists broken records of single archive or folder of direct files.
+type Group struct {
+	// Path is relative to library.
+	Path    string `json:"path"`
+	Archive bool   `json:"archive"`
+	// Reason is set when archive itself is missing or can't be read.
+	Reason   string     `json:"reason,omitempty"`
+	Problems []*Problem `json:"problems"`
+}
+
+// Report is a result of verification.
+type Report struct {
+	Checked int      `json:"checked"`
+	Broken  int      `json:"broken"`
+	Groups  []*Group `json:"groups"`
+	// Fixed are ids of records marked as broken before, which files are found now.
+	Fixed []string `json:"fixed"`
+	// Archives maps archives to folders they are found in.
+	Archives map[string]string `json:"archives"`
+}
+
+// archiveListing maps names of archive members to their sizes, nil when archive can't be read.
+type archiveListing struct {
+	members map[string]int64
+	reason  string
+}
+
+// Verifier checks books against library folders.
+type Verifier struct {
+	resolver *library.Resolver
+	archives map[string]*archiveListing
+}
+
+func New(resolver *library.Resolver) *Verifier {
+	return &Verifier{
+		resolver: resolver,
+		archives: make(map[string]*archiveListing),
+	}
+}
+
+// Check returns problem of book file, nil if file is fine.
+// Archive listings are cached, so every archive is read once.
+func (v *Verifier) Check(book *model.Book) *Problem {
+	if book.File.IsArchived() {
+		archivePath := book.File.ArchivePath()
+		name := fmt.Sprintf("%s.%s", book.File.Name, book.File.Ext)
+		listing := v.listArchive(archivePath)
+		if listing.members == nil {
+			return &Problem{LibId: book.LibId, File: name, Reason: listing.reason}
+		}
+
+		size, ok := listing.members[name]
+		if !ok {
+			return &Problem{LibId: book.LibId, File: name, Reason: ReasonMemberNotFound}
+		}
+		return checkSize(book, name, size)
+	}
+
+	name := path.Join(book.File.Folder, book.File.Name)
+	stat, err := v.resolver.Store(name).Stat(name)
+	if err != nil {
+		return &Problem{LibId: book.LibId, File: name, Reason: ReasonFileNotFound}
+	}
+	return checkSize(book, name, stat.Size())
+}
+
+func checkSize(book *model.Book, name string, size int64) *Problem {
+	if book.File.Size > 0 && int64(book.File.Size) != size {
+		return &Problem{LibId: book.LibId, File: name, Reason: ReasonSizeMismatch, Expected: int64(book.File.Size), Actual: size}
+	}
+	return nil
+}
+
+func (v *Verifier) listArchive(name string) *archiveListing {
+	if listing, ok := v.archives[name]; ok {
+		return listing
+	}
+
+	listing := &archiveListing{}
+	f, err := v.resolver.Store(name).Open(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		listing.reason = ReasonArchiveNotFound
+	case err != nil:
+		listing.reason = ReasonArchiveBroken
+	default:
+		defer f.Close()
+		zr, err := zip.NewReader(f, f.Size())
+		if err != nil {
+			listing.reason = ReasonArchiveBroken
+			break
+		}
+		listing.members = make(map[string]int64, len(zr.File))
+		for _, zf := range zr.File {
+			listing.members[zf.Name] = int64(zf.UncompressedSize64)
+		}
+	}
+
+	v.archives[name] = listing
+	return listing
+}
+
+// Verify checks all books in index. When mark is set, broken flag of records is updated.
+func Verify(ctx context.Context, index *db.Store, resolver *library.Resolver, mark bool, progress func(checked int)) (*Report, error) {
+	v := New(resolver)
+	report := &Report{Groups: []*Group{}, Fixed: []string{}, Archives: make(map[string]string)}
+	groups := make(map[string]*Group)
+	var changed []*model.Book
+
+	for book, err := range index.Books("") {
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		report.Checked++
+		if progress != nil && report.Checked%1000 == 0 {
+			progress(report.Checked)
+		}
+
+		problem := v.Check(book)
+		if problem == nil {
+			if book.Broken {
+				report.Fixed = append(report.Fixed, book.LibId)
+			}
+		} else {
+			report.Broken++
+			groupPath, isArchive := book.File.Folder, false
+			if book.File.IsArchived() {
+				groupPath, isArchive = book.File.ArchivePath(), true
+			}
+
+			group, ok := groups[groupPath]
+			if !ok {
+				group = &Group{Path: groupPath, Archive: isArchive}
+				if isArchive && v.listArchive(groupPath).members == nil {
+					group.Reason = problem.Reason
+				}
+				groups[groupPath] = group
+				report.Groups = append(report.Groups, group)
+			}
+			group.Problems = append(group.Problems, problem)
+		}
+
+		if mark && book.Broken != (problem != nil) {
+			book.Broken = problem != nil
+			changed = append(changed, book)
+		}
+	}
+
+	for name, listing := range v.archives {
+		if listing.reason != ReasonArchiveNotFound {
+			report.Archives[name], _ = resolver.Lookup(name)
+		}
+	}
+
+	slices.SortFunc(report.Groups, func(a, b *Group) int {
+		if a.Path < b.Path {
+			return -1
+		}
+		if a.Path > b.Path {
+			return 1
+		}
+		return 0
+	})
+
+	for start := 0; start < len(changed); start += markBatchSize {
+		end := min(start+markBatchSize, len(changed))
+		if err := index.AddBooks(changed[start:end], false); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// markBatchSize is an amount of records updated at once.
+const markBatchSize = 1000
@@ -0,0 +1,120 @@
+package verifier
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/db"
+	"github.com/shemanaev/inpxer/internal/library"
+	"github.com/shemanaev/inpxer/internal/model"
+)
+
+func writeZip(t *testing.T, name string, files map[string]string) {
+	t.Helper()
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	writeZip(t, filepath.Join(dir, "books-1.zip"), map[string]string{
+		"1.fb2": "archived book",
+		"2.fb2": "short",
+	})
+	if err := os.WriteFile(filepath.Join(dir, "broken.zip"), []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "loose"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "loose", "Book.epub"), []byte("loose book"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := db.Create(t.Name(), "en", "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	err = index.AddBooks([]*model.Book{
+		{LibId: "1", Title: "Fine", Broken: true, File: model.File{Name: "1", Size: 13, Ext: "fb2", Archive: "books-1"}},
+		{LibId: "2", Title: "Size", File: model.File{Name: "2", Size: 10, Ext: "fb2", Archive: "books-1"}},
+		{LibId: "3", Title: "Member", File: model.File{Name: "3", Size: 10, Ext: "fb2", Archive: "books-1"}},
+		{LibId: "4", Title: "Archive", File: model.File{Name: "4", Size: 10, Ext: "fb2", Folder: "missing.zip"}},
+		{LibId: "5", Title: "Damaged", File: model.File{Name: "5", Size: 10, Ext: "fb2", Archive: "broken"}},
+		{LibId: "6", Title: "Loose", File: model.File{Name: "Book.epub", Size: 10, Ext: "epub", Folder: "loose"}},
+		{LibId: "7", Title: "Gone", File: model.File{Name: "Gone.epub", Size: 10, Ext: "epub", Folder: "loose"}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver, err := library.NewResolver(&config.MyConfig{LibraryPath: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Verify(context.Background(), index, resolver, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, report.Checked)
+	assert.Equal(t, 5, report.Broken)
+	assert.Equal(t, []string{"1"}, report.Fixed)
+	assert.Equal(t, map[string]string{"books-1.zip": dir, "broken.zip": dir}, report.Archives)
+	assert.Equal(t, []*Group{
+		{Path: "books-1.zip", Archive: true, Problems: []*Problem{
+			{LibId: "2", File: "2.fb2", Reason: ReasonSizeMismatch, Expected: 10, Actual: 5},
+			{LibId: "3", File: "3.fb2", Reason: ReasonMemberNotFound},
+		}},
+		{Path: "broken.zip", Archive: true, Reason: ReasonArchiveBroken, Problems: []*Problem{
+			{LibId: "5", File: "5.fb2", Reason: ReasonArchiveBroken},
+		}},
+		{Path: "loose", Problems: []*Problem{
+			{LibId: "7", File: "loose/Gone.epub", Reason: ReasonFileNotFound},
+		}},
+		{Path: "missing.zip", Archive: true, Reason: ReasonArchiveNotFound, Problems: []*Problem{
+			{LibId: "4", File: "4.fb2", Reason: ReasonArchiveNotFound},
+		}},
+	}, report.Groups)
+
+	// Nothing is changed without mark.
+	book, err := index.GetBookById("2")
+	assert.Nil(t, err)
+	assert.False(t, book.Broken)
+
+	_, err = Verify(context.Background(), index, resolver, true, nil)
+	assert.Nil(t, err)
+
+	for id, broken := range map[string]bool{"1": false, "2": true, "3": true, "6": false, "7": true} {
+		book, err := index.GetBookById(id)
+		assert.Nil(t, err)
+		assert.Equal(t, broken, book.Broken, id)
+	}
+
+	// Marked records are still reported, but not as fixed.
+	report, err = Verify(context.Background(), index, resolver, true, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, report.Broken)
+	assert.Empty(t, report.Fixed)
+}
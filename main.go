@@ -2,16 +2,30 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/shemanaev/inpxer/internal/config"
+	"github.com/shemanaev/inpxer/internal/db"
+	"github.com/shemanaev/inpxer/internal/exporter"
 	"github.com/shemanaev/inpxer/internal/indexer"
+	"github.com/shemanaev/inpxer/internal/library"
+	"github.com/shemanaev/inpxer/internal/scanner"
 	"github.com/shemanaev/inpxer/internal/server"
+	"github.com/shemanaev/inpxer/internal/verifier"
+	"github.com/shemanaev/inpxer/pkg/inpx"
 )
 
 var (
@@ -35,22 +49,170 @@ func main() {
 				Usage:   "start server",
 				Action:  serveAction,
 				Before:  loadConfig,
+				Flags: []cli.Flag{
+					libraryFlag(),
+					&cli.StringFlag{
+						Name:  "import",
+						Usage: "Import `FILE` before starting, required for \"memory\" storage",
+					},
+				},
 			},
 			{
 				Name:    "import",
 				Aliases: []string{"i"},
-				Usage:   "import .inpx file",
+				Usage:   "import .inpx file (\"-\" reads it from stdin) or folder of books with --scan",
 				Action:  importAction,
-				Before:  loadConfig,
+				Before:  loadLibraryConfig,
 				Flags: []cli.Flag{
+					libraryFlag(),
 					&cli.BoolFlag{
 						Name:  "keep-deleted",
 						Usage: "Keep records marked as \"Deleted\" in inp",
 					},
+					&cli.BoolFlag{
+						Name:  "scan",
+						Usage: "Import book files from folder (library_path by default) without .inpx, only changed files are read on rescan",
+					},
 					&cli.BoolFlag{
 						Name:  "partial",
 						Usage: "Only add new records, never delete",
 					},
+					&cli.IntFlag{
+						Name:  "max-errors",
+						Usage: "Skip up to `N` malformed records before aborting, -1 for unlimited",
+					},
+					&cli.StringFlag{
+						Name:        "encoding",
+						Usage:       "Encoding of .inp files, e.g. cp1251 or koi8-r",
+						DefaultText: "inpx_encoding from config or auto",
+					},
+					&cli.IntFlag{
+						Name:        "workers",
+						Usage:       "Decode up to `N` .inp files concurrently",
+						DefaultText: "number of CPUs",
+					},
+				},
+			},
+			{
+				Name:   "info",
+				Usage:  "show information about imported collection",
+				Action: infoAction,
+				Before: loadLibraryConfig,
+				Flags:  []cli.Flag{libraryFlag()},
+			},
+			{
+				Name:   "reindex",
+				Usage:  "rebuild full-text index from stored books",
+				Action: reindexAction,
+				Before: loadLibraryConfig,
+				Flags:  []cli.Flag{libraryFlag()},
+			},
+			{
+				Name:   "migrate",
+				Usage:  "upgrade index created by older version",
+				Action: migrateAction,
+				Before: loadLibraryConfig,
+				Flags:  []cli.Flag{libraryFlag()},
+			},
+			{
+				Name:      "diff",
+				Usage:     "show records added, removed, deleted and changed between two .inpx files",
+				ArgsUsage: "OLD NEW",
+				Action:    diffAction,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print changes as JSON",
+					},
+				},
+			},
+			{
+				Name:   "export-inpx",
+				Usage:  "write books matching query and filters as a new .inpx file",
+				Action: exportInpxAction,
+				Before: loadLibraryConfig,
+				Flags: []cli.Flag{
+					libraryFlag(),
+					&cli.StringFlag{
+						Name:     "out",
+						Aliases:  []string{"o"},
+						Usage:    "Write catalog to `FILE`",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:    "query",
+						Aliases: []string{"q"},
+						Usage:   "Full-text search `QUERY`, all books are exported if not set",
+					},
+					&cli.StringFlag{
+						Name:  "field",
+						Usage: "Search only in `FIELD`: Title, Authors or Series",
+					},
+					&cli.StringSliceFlag{
+						Name:  "lang",
+						Usage: "Only books in language `LANG`, can be repeated",
+					},
+					&cli.StringSliceFlag{
+						Name:  "genre",
+						Usage: "Only books with genre `GENRE`, can be repeated",
+					},
+					&cli.StringSliceFlag{
+						Name:  "ext",
+						Usage: "Only books with file extension `EXT`, can be repeated",
+					},
+					&cli.StringFlag{
+						Name:  "repack",
+						Usage: "Copy book files into new archives in `DIR`",
+					},
+					&cli.StringFlag{
+						Name:        "name",
+						Usage:       "Collection name",
+						DefaultText: "title from config",
+					},
+					&cli.StringFlag{
+						Name:  "comment",
+						Usage: "Collection description",
+					},
+				},
+			},
+			{
+				Name:   "verify",
+				Usage:  "check that files of all books are present in library",
+				Action: verifyAction,
+				Before: loadLibraryConfig,
+				Flags: []cli.Flag{
+					libraryFlag(),
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print report as JSON",
+					},
+					&cli.BoolFlag{
+						Name:  "mark",
+						Usage: "Flag broken records in index, so they are marked in web interface and OPDS",
+					},
+				},
+			},
+			{
+				Name:      "build-inpx",
+				Usage:     "generate .inpx catalog from a folder of books",
+				ArgsUsage: "DIR",
+				Action:    buildInpxAction,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "out",
+						Aliases:     []string{"o"},
+						Usage:       "Write catalog to `FILE`",
+						DefaultText: "<folder name>.inpx",
+					},
+					&cli.StringFlag{
+						Name:        "name",
+						Usage:       "Collection name",
+						DefaultText: "folder name",
+					},
+					&cli.StringFlag{
+						Name:  "comment",
+						Usage: "Collection description",
+					},
 				},
 			},
 		},
@@ -67,17 +229,382 @@ func main() {
 
 func importAction(ctx *cli.Context) error {
 	cfg := ctx.Context.Value(contextConfig).(*config.MyConfig)
-	fmt.Println("Starting import from:", ctx.Args().First())
-	return indexer.Run(cfg, ctx.Args().First(), ctx.Bool("keep-deleted"), ctx.Bool("partial"))
+	if db.IsMemory(cfg.Storage) {
+		return cli.Exit("index in memory storage is lost on exit, it's imported by serve command on start instead", 1)
+	}
+
+	if ctx.Bool("scan") {
+		dir := ctx.Args().First()
+		if dir == "" {
+			dir = cfg.LibraryPath
+		}
+
+		fmt.Println("Starting scan of:", dir)
+		ctx, stop := signal.NotifyContext(ctx.Context, os.Interrupt)
+		defer stop()
+
+		return indexer.Scan(ctx, cfg, dir)
+	}
+
+	filename := ctx.Args().First()
+	if filename == "" {
+		filename = cfg.Inpx
+	}
+
+	fmt.Println("Starting import from:", filename)
+	return runImport(ctx.Context, cfg, filename, indexer.Options{
+		KeepDeleted: ctx.Bool("keep-deleted"),
+		Partial:     ctx.Bool("partial"),
+		MaxErrors:   ctx.Int("max-errors"),
+		Workers:     ctx.Int("workers"),
+		Encoding:    ctx.String("encoding"),
+	})
+}
+
+func infoAction(ctx *cli.Context) error {
+	cfg := ctx.Context.Value(contextConfig).(*config.MyConfig)
+	index, err := db.Open(cfg.IndexPath, cfg.Storage)
+	if err != nil {
+		log.Printf("Error opening index: %s", cfg.IndexPath)
+		return cli.Exit(err.Error(), 1)
+	}
+	defer index.Close()
+
+	info, err := index.Info()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	count, err := index.Count()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	version, err := index.SchemaVersion()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Index:\t%s\n", cfg.IndexPath)
+	fmt.Fprintf(w, "Schema version:\t%d\n", version)
+	fmt.Fprintf(w, "Books:\t%d\n", count)
+	if info == nil {
+		fmt.Fprintln(w, "Collection:\tunknown, reimport collection to record it")
+		return w.Flush()
+	}
+
+	imported := info.ImportedAt.Local().Format(time.DateTime)
+	if info.Partial {
+		imported += " (partial)"
+	}
+
+	fmt.Fprintf(w, "Collection:\t%s\n", info.Name)
+	fmt.Fprintf(w, "Collection id:\t%d\n", info.Id)
+	fmt.Fprintf(w, "Comment:\t%s\n", info.Comment)
+	fmt.Fprintf(w, "Version:\t%s\n", info.Version)
+	fmt.Fprintf(w, "Imported at:\t%s\n", imported)
+	fmt.Fprintf(w, "Source:\t%s\n", info.Source)
+	fmt.Fprintf(w, "SHA-256:\t%s\n", info.SourceSHA256)
+	fmt.Fprintf(w, "Records processed:\t%d\n", info.Processed)
+	fmt.Fprintf(w, "Imported:\t%d\n", info.Imported)
+	fmt.Fprintf(w, "Duplicates:\t%d\n", info.Duplicates)
+	fmt.Fprintf(w, "Deleted:\t%d\n", info.Deleted)
+	fmt.Fprintf(w, "Malformed:\t%d\n", info.Malformed)
+
+	return w.Flush()
+}
+
+func reindexAction(ctx *cli.Context) error {
+	cfg := ctx.Context.Value(contextConfig).(*config.MyConfig)
+	fmt.Println("Rebuilding full-text index:", cfg.IndexPath)
+	return indexer.Reindex(cfg)
+}
+
+func migrateAction(ctx *cli.Context) error {
+	cfg := ctx.Context.Value(contextConfig).(*config.MyConfig)
+	fmt.Println("Migrating index:", cfg.IndexPath)
+	from, err := db.Migrate(cfg.IndexPath, cfg.Storage)
+	if err != nil {
+		log.Printf("Error migrating index: %s", cfg.IndexPath)
+		return cli.Exit(err.Error(), 1)
+	}
+
+	if from == db.SchemaVersion {
+		fmt.Println("Index is up to date")
+	} else {
+		fmt.Printf("Index migrated from version %d to %d\n", from, db.SchemaVersion)
+	}
+
+	return nil
+}
+
+func buildInpxAction(ctx *cli.Context) error {
+	dir := ctx.Args().First()
+	if dir == "" {
+		return cli.Exit("folder with books is required", 1)
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	name := ctx.String("name")
+	if name == "" {
+		name = filepath.Base(abs)
+	}
+	out := ctx.String("out")
+	if out == "" {
+		out = filepath.Base(abs) + ".inpx"
+	}
+
+	fmt.Println("Scanning:", abs)
+	count, err := buildInpx(abs, out, name, ctx.String("comment"))
+	if err != nil {
+		log.Printf("Error building catalog: %s", out)
+		return cli.Exit(err.Error(), 1)
+	}
+
+	fmt.Printf("Catalog with %d books written to: %s\n", count, out)
+	return nil
+}
+
+func diffAction(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return cli.Exit("old and new .inpx files are required", 1)
+	}
+
+	c, stop := signal.NotifyContext(ctx.Context, os.Interrupt)
+	defer stop()
+
+	diff, err := inpx.CompareFiles(c, ctx.Args().Get(0), ctx.Args().Get(1), inpx.WithWorkers(runtime.NumCPU()))
+	if err != nil {
+		log.Printf("Error comparing collections")
+		return cli.Exit(err.Error(), 1)
+	}
+
+	if ctx.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+
+	fmt.Printf("Added: %d, removed: %d, deleted: %d, changed: %d\n", len(diff.Added), len(diff.Removed), len(diff.Deleted), len(diff.Changed))
+	for _, id := range diff.Added {
+		fmt.Printf("+ %d\n", id)
+	}
+	for _, id := range diff.Removed {
+		fmt.Printf("- %d\n", id)
+	}
+	for _, id := range diff.Deleted {
+		fmt.Printf("x %d\n", id)
+	}
+	for _, change := range diff.Changed {
+		fmt.Printf("~ %d\n", change.LibId)
+		for _, f := range change.Fields {
+			fmt.Printf("    %s: %q -> %q\n", f.Field, f.Old, f.New)
+		}
+	}
+
+	return nil
+}
+
+func verifyAction(ctx *cli.Context) error {
+	cfg := ctx.Context.Value(contextConfig).(*config.MyConfig)
+	index, err := db.Open(cfg.IndexPath, cfg.Storage)
+	if err != nil {
+		log.Printf("Error opening index: %s", cfg.IndexPath)
+		return cli.Exit(err.Error(), 1)
+	}
+	defer index.Close()
+	index.IndexExtraFields(cfg.ExtraFieldNames())
+
+	c, stop := signal.NotifyContext(ctx.Context, os.Interrupt)
+	defer stop()
+
+	resolver, err := library.NewResolver(cfg)
+	if err != nil {
+		log.Printf("Error opening library: %v", err)
+		return cli.Exit(err.Error(), 1)
+	}
+
+	folders := cfg.Folders()
+	log.Printf("Verifying books in: %s", strings.Join(folders, ", "))
+	report, err := verifier.Verify(c, index, resolver, ctx.Bool("mark"), func(checked int) {
+		log.Printf("Checked: %d", checked)
+	})
+	if err != nil {
+		log.Printf("Error verifying library: %s", cfg.LibraryPath)
+		return cli.Exit(err.Error(), 1)
+	}
+
+	if ctx.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	for _, group := range report.Groups {
+		if group.Reason != "" {
+			fmt.Printf("%s: %s, books: %d\n", group.Path, group.Reason, len(group.Problems))
+			continue
+		}
+
+		fmt.Printf("%s, broken: %d\n", group.Path, len(group.Problems))
+		for _, problem := range group.Problems {
+			fmt.Printf("    %s\n", problem)
+		}
+	}
+	if len(report.Fixed) > 0 {
+		fmt.Printf("Previously broken, found now: %d\n", len(report.Fixed))
+	}
+	if len(folders) > 1 || len(cfg.LibraryRoots) > 0 {
+		printArchiveFolders(report.Archives)
+	}
+	fmt.Printf("Checked: %d, broken: %d\n", report.Checked, report.Broken)
+
+	return nil
+}
+
+// printArchiveFolders lists archives by folders they are found in.
+func printArchiveFolders(archives map[string]string) {
+	byFolder := make(map[string][]string)
+	for name, folder := range archives {
+		byFolder[folder] = append(byFolder[folder], name)
+	}
+
+	folders := slices.Sorted(maps.Keys(byFolder))
+	for _, folder := range folders {
+		names := byFolder[folder]
+		slices.Sort(names)
+		fmt.Printf("%s, archives: %d\n", folder, len(names))
+		for _, name := range names {
+			fmt.Printf("    %s\n", name)
+		}
+	}
+}
+
+func exportInpxAction(ctx *cli.Context) error {
+	cfg := ctx.Context.Value(contextConfig).(*config.MyConfig)
+	name := ctx.String("name")
+	if name == "" {
+		name = cfg.Title
+	}
+
+	out := ctx.String("out")
+	fmt.Println("Exporting to:", out)
+
+	c, stop := signal.NotifyContext(ctx.Context, os.Interrupt)
+	defer stop()
+
+	return exporter.Run(c, cfg, out, exporter.Options{
+		Query:     ctx.String("query"),
+		Field:     ctx.String("field"),
+		Languages: ctx.StringSlice("lang"),
+		Genres:    ctx.StringSlice("genre"),
+		Exts:      ctx.StringSlice("ext"),
+		Repack:    ctx.String("repack"),
+		Name:      name,
+		Comment:   ctx.String("comment"),
+	})
+}
+
+// buildInpx writes catalog of books in dir. Partially written file is removed on error.
+func buildInpx(dir, out, name, comment string) (count int, err error) {
+	f, err := os.Create(out)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(out)
+		}
+	}()
+
+	w := inpx.NewWriter(f)
+	w.Name = name
+	w.FileName = filepath.Base(out)
+	w.Comment = comment
+
+	err = scanner.New(dir).Scan(func(e *scanner.Entry) error {
+		count++
+		return w.Add(e.Book)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, w.Close()
+}
+
+// runImport imports collection stopping on interrupt.
+func runImport(ctx context.Context, cfg *config.MyConfig, filename string, opts indexer.Options) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	return indexer.Run(ctx, cfg, filename, opts)
 }
 
 func serveAction(ctx *cli.Context) error {
 	cfg := ctx.Context.Value(contextConfig).(*config.MyConfig)
 	isDevMode := version == "dev"
+
+	if filename := ctx.String("import"); filename != "" {
+		library, err := cfg.Library(ctx.String("library"))
+		if err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+
+		fmt.Println("Starting import from:", filename)
+		if err := runImport(ctx.Context, library, filename, indexer.Options{}); err != nil {
+			return err
+		}
+	} else if db.IsMemory(cfg.Storage) {
+		for _, library := range cfg.AllLibraries() {
+			if library.Inpx == "" {
+				continue
+			}
+
+			fmt.Println("Starting import from:", library.Inpx)
+			if err := runImport(ctx.Context, library, library.Inpx, indexer.Options{}); err != nil {
+				return err
+			}
+		}
+	}
+
 	fmt.Printf("Starting web server on: http://%s\n", cfg.Listen)
 	return server.Run(cfg, isDevMode, version)
 }
 
+// libraryFlag selects one of [[libraries]] from configuration.
+func libraryFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:        "library",
+		Usage:       "Use library with `ID` from [[libraries]] in config",
+		DefaultText: "the only library",
+	}
+}
+
+// loadLibraryConfig loads configuration of library selected with --library.
+func loadLibraryConfig(ctx *cli.Context) error {
+	if err := loadConfig(ctx); err != nil {
+		return err
+	}
+
+	cfg := ctx.Context.Value(contextConfig).(*config.MyConfig)
+	library, err := cfg.Library(ctx.String("library"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	ctx.Context = context.WithValue(ctx.Context, contextConfig, library)
+	return nil
+}
+
 func loadConfig(ctx *cli.Context) error {
 	cfg, err := config.Load()
 	if err != nil {
@@ -0,0 +1,171 @@
+package inpx
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Names of fields compared by Compare.
+const (
+	FieldAuthors  = "authors"
+	FieldGenres   = "genres"
+	FieldTitle    = "title"
+	FieldSeries   = "series"
+	FieldSeriesNo = "series_no"
+	FieldFile     = "file"
+	FieldSize     = "size"
+	FieldExt      = "ext"
+	FieldFolder   = "folder"
+	FieldArchive  = "archive"
+	FieldDate     = "date"
+	FieldLanguage = "lang"
+	FieldDeleted  = "deleted"
+)
+
+var diffFields = []string{
+	FieldAuthors, FieldGenres, FieldTitle, FieldSeries, FieldSeriesNo, FieldFile, FieldSize,
+	FieldExt, FieldFolder, FieldArchive, FieldDate, FieldLanguage, FieldDeleted,
+}
+
+// FieldChange is a difference of single field, values are formatted as in .inp file.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// BookChange lists changed fields of record.
+type BookChange struct {
+	LibId  int           `json:"id"`
+	Fields []FieldChange `json:"fields"`
+}
+
+// Diff describes changes between two collections. Ids are sorted.
+type Diff struct {
+	Added   []int `json:"added"`
+	Removed []int `json:"removed"`
+	// Deleted are records marked as deleted in new collection only.
+	Deleted []int        `json:"deleted"`
+	Changed []BookChange `json:"changed"`
+}
+
+// Empty reports whether collections have the same records.
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Deleted) == 0 && len(d.Changed) == 0
+}
+
+// Compare reports changes of records between collections. Records are matched by LibId,
+// the first one wins when collection has duplicates. Only one collection is kept in memory.
+func Compare(ctx context.Context, old, new *Parser) (*Diff, error) {
+	oldBooks := make(map[int][]string)
+	for book, err := range old.Books(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := oldBooks[book.LibId]; !ok {
+			oldBooks[book.LibId] = diffValues(book)
+		}
+	}
+
+	diff := &Diff{
+		Added:   []int{},
+		Removed: []int{},
+		Deleted: []int{},
+		Changed: []BookChange{},
+	}
+	seen := make(map[int]bool)
+	for book, err := range new.Books(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		if seen[book.LibId] {
+			continue
+		}
+		seen[book.LibId] = true
+
+		values, ok := oldBooks[book.LibId]
+		if !ok {
+			diff.Added = append(diff.Added, book.LibId)
+			continue
+		}
+
+		change := BookChange{LibId: book.LibId}
+		for i, value := range diffValues(book) {
+			if value == values[i] {
+				continue
+			}
+			if diffFields[i] == FieldDeleted && book.Deleted {
+				diff.Deleted = append(diff.Deleted, book.LibId)
+				continue
+			}
+			change.Fields = append(change.Fields, FieldChange{Field: diffFields[i], Old: values[i], New: value})
+		}
+		if len(change.Fields) > 0 {
+			diff.Changed = append(diff.Changed, change)
+		}
+	}
+
+	for id := range oldBooks {
+		if !seen[id] {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Removed)
+	slices.Sort(diff.Deleted)
+	slices.SortFunc(diff.Changed, func(a, b BookChange) int { return a.LibId - b.LibId })
+
+	return diff, nil
+}
+
+// CompareFiles opens collections and reports changes between them.
+func CompareFiles(ctx context.Context, oldName, newName string, opts ...Option) (*Diff, error) {
+	old, err := Open(oldName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer old.Close()
+
+	new, err := Open(newName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer new.Close()
+
+	return Compare(ctx, old, new)
+}
+
+// diffValues returns fields of book in order of diffFields.
+func diffValues(book *Book) []string {
+	authors := make([]string, len(book.Authors))
+	for i, a := range book.Authors {
+		authors[i] = a.LastName + "," + a.FirstName + "," + a.MiddleName
+	}
+
+	seriesNo, date := "", ""
+	if book.SeriesNo > 0 {
+		seriesNo = strconv.Itoa(book.SeriesNo)
+	}
+	if !book.PublishedDate.IsZero() {
+		date = book.PublishedDate.Format("2006-01-02")
+	}
+
+	return []string{
+		strings.Join(authors, ":"),
+		strings.Join(book.Genres, ":"),
+		book.Title,
+		book.Series,
+		seriesNo,
+		book.File.Name,
+		strconv.Itoa(book.File.Size),
+		book.File.Ext,
+		book.File.Folder,
+		book.File.Archive,
+		date,
+		book.Language,
+		strconv.FormatBool(book.Deleted),
+	}
+}
@@ -0,0 +1,35 @@
+package inpx
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	deleted := strings.Replace(testRecord("4", "Deleted"), "\x040\x04", "\x041\x04", 1)
+	oldName := writeTestInpx(t, map[string]string{
+		"a.inp": testRecord("1", "Same") + testRecord("2", "Old title") + testRecord("3", "Removed") + testRecord("4", "Deleted"),
+	})
+	newName := writeTestInpx(t, map[string]string{
+		"a.inp": testRecord("1", "Same") + testRecord("2", "New title") + deleted,
+		"b.inp": testRecord("5", "Added") + testRecord("1", "Duplicate"),
+	})
+
+	diff, err := CompareFiles(context.Background(), oldName, newName)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []int{5}, diff.Added)
+	assert.Equal(t, []int{3}, diff.Removed)
+	assert.Equal(t, []int{4}, diff.Deleted)
+	assert.Equal(t, []BookChange{{LibId: 2, Fields: []FieldChange{{Field: FieldTitle, Old: "Old title", New: "New title"}}}}, diff.Changed)
+	assert.False(t, diff.Empty())
+
+	same, err := CompareFiles(context.Background(), oldName, oldName)
+	assert.NoError(t, err)
+	assert.True(t, same.Empty())
+}
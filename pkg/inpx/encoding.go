@@ -0,0 +1,133 @@
+package inpx
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// fallbackEncoding is used for text which isn't valid UTF-8 when encoding isn't set.
+var fallbackEncoding = charmap.Windows1251
+
+var utf8BOM = []byte("\uFEFF")
+
+// mojibakeRe matches UTF-8 text decoded as Latin-1 or Windows-1251.
+var mojibakeRe = regexp.MustCompile(`(?:[ÐÑ][\x{80}-\x{BF}]){2}|(?:[РС][ЂЃ‚ѓ„…†‡€‰Љ‹ЊЌЋЏђ‘’“”•–—™љ›њќћџЎўЈ¤Ґ¦§Ё©Є«¬®Ї°±Ііґµ¶·ё№є»јЅѕї]){2}`)
+
+// Warning describes record which was read, but its text is probably broken.
+type Warning struct {
+	// Archive is a name of .inp file without extension.
+	Archive string
+	Line    int
+	Reason  string
+}
+
+func (w *Warning) String() string {
+	return fmt.Sprintf("%s.inp:%d: %s", w.Archive, w.Line, w.Reason)
+}
+
+// LookupEncoding returns encoding by its name, e.g. "utf-8", "cp1251" or "koi8-r".
+// Empty name and "auto" return nil, which means detection.
+func LookupEncoding(name string) (encoding.Encoding, error) {
+	if name == "" || strings.EqualFold(name, "auto") {
+		return nil, nil
+	}
+
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+	return enc, nil
+}
+
+// textDecoder converts text of single file to UTF-8.
+type textDecoder struct {
+	// enc is nil when encoding is detected for every line.
+	enc              encoding.Encoding
+	fallbackReported bool
+}
+
+// decode returns line in UTF-8 and description of suspicious content, if any.
+// Without encoding, lines which aren't valid UTF-8 are decoded as Windows-1251.
+func (d *textDecoder) decode(line []byte) ([]byte, string) {
+	line = bytes.ReplaceAll(line, utf8BOM, nil)
+
+	var reason string
+	switch {
+	case d.enc != nil && d.enc != unicode.UTF8:
+		decoded, err := d.enc.NewDecoder().Bytes(line)
+		if err != nil {
+			return line, fmt.Sprintf("can't be decoded: %v", err)
+		}
+		line = bytes.ReplaceAll(decoded, utf8BOM, nil)
+	case d.enc == nil && !utf8.Valid(line):
+		line, _ = fallbackEncoding.NewDecoder().Bytes(line)
+		if !d.fallbackReported {
+			d.fallbackReported = true
+			reason = "not valid UTF-8, decoded as windows-1251"
+		}
+	}
+
+	if reason == "" {
+		reason = suspiciousText(line)
+	}
+	return line, reason
+}
+
+// suspiciousText describes content which is unlikely to be in correctly decoded record.
+func suspiciousText(line []byte) string {
+	if !utf8.Valid(line) {
+		return "not valid UTF-8"
+	}
+	if bytes.ContainsRune(line, utf8.RuneError) {
+		return "contains replacement character"
+	}
+	if i := bytes.IndexFunc(line, isControl); i >= 0 {
+		return fmt.Sprintf("contains control character %#02x", line[i])
+	}
+	if m := mojibakeRe.Find(line); m != nil {
+		return fmt.Sprintf("looks like text in wrong encoding: %q", m)
+	}
+	return ""
+}
+
+// isControl reports control characters except separators and whitespace.
+func isControl(r rune) bool {
+	return r < 0x20 && r != 0x04 && r != '\t' && r != '\r' && r != '\n'
+}
+
+// addWarning records suspicious line, it's safe for concurrent use.
+func (p *Parser) addWarning(archive string, line int, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.warnings = append(p.warnings, &Warning{Archive: archive, Line: line, Reason: reason})
+}
+
+// Warnings returns records with suspicious text found so far, ordered by file and line.
+func (p *Parser) Warnings() []*Warning {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	warnings := slices.Clone(p.warnings)
+	slices.SortFunc(warnings, func(a, b *Warning) int {
+		if c := strings.Compare(a.Archive, b.Archive); c != 0 {
+			return c
+		}
+		return a.Line - b.Line
+	})
+	return warnings
+}
+
+// decodeString converts meta info to UTF-8.
+func (p *Parser) decodeString(s string) string {
+	decoded, _ := (&textDecoder{enc: p.encoding}).decode([]byte(s))
+	return strings.Trim(strings.TrimSpace(string(decoded)), extraTrimChars)
+}
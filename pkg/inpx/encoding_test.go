@@ -0,0 +1,80 @@
+package inpx
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func encode(t *testing.T, enc *charmap.Charmap, s string) string {
+	t.Helper()
+	encoded, err := enc.NewEncoder().String(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func readTitles(t *testing.T, p *Parser) []string {
+	t.Helper()
+	var titles []string
+	for book, err := range p.Books(context.Background()) {
+		if !assert.NoError(t, err) {
+			break
+		}
+		titles = append(titles, book.Title)
+	}
+	return titles
+}
+
+func TestEncodingDetection(t *testing.T) {
+	name := writeTestInpx(t, map[string]string{
+		"a.inp": testRecord("1", "Мастер и Маргарита") +
+			encode(t, charmap.Windows1251, testRecord("2", "Собачье сердце")+testRecord("3", "Белая гвардия")),
+		// Bare CR separators and stray BOMs.
+		"b.inp": "\uFEFF" + strings.TrimSuffix(testRecord("4", "Бег"), "\n") + "\uFEFF" + strings.TrimSuffix(testRecord("5", "Роковые яйца"), "\n"),
+		// UTF-8 read as Windows-1251 before packing.
+		"c.inp": testRecord("6", "РњР°СЃС‚РµСЂ"),
+	})
+
+	p, err := Open(name)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer p.Close()
+
+	assert.Equal(t, []string{"Мастер и Маргарита", "Собачье сердце", "Белая гвардия", "Бег", "Роковые яйца", "РњР°СЃС‚РµСЂ"}, readTitles(t, p))
+	assert.Equal(t, []*Warning{
+		{Archive: "a", Line: 2, Reason: "not valid UTF-8, decoded as windows-1251"},
+		{Archive: "c", Line: 1, Reason: `looks like text in wrong encoding: "РњР°"`},
+	}, p.Warnings())
+}
+
+func TestEncodingOption(t *testing.T) {
+	enc, err := LookupEncoding("koi8-r")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	name := writeTestInpx(t, map[string]string{
+		"a.inp": encode(t, charmap.KOI8R, testRecord("1", "Мастер и Маргарита")),
+	})
+
+	p, err := Open(name, WithEncoding(enc))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer p.Close()
+
+	assert.Equal(t, []string{"Мастер и Маргарита"}, readTitles(t, p))
+	assert.Empty(t, p.Warnings())
+
+	_, err = LookupEncoding("unknown")
+	assert.Error(t, err)
+	enc, err = LookupEncoding("auto")
+	assert.NoError(t, err)
+	assert.Nil(t, enc)
+}
@@ -0,0 +1,290 @@
+package inpx
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testRecord returns record with default structure.
+func testRecord(id, title string) string {
+	fields := []string{"Автор,Имя,:", "sf:", title, "", "", id, "100", id, "0", "fb2", "2020-01-02", "ru", "", ""}
+	return strings.Join(fields, "\x04") + "\x04\r\n"
+}
+
+// writeTestInpx creates collection from files in temporary directory and returns its path.
+// Files are stored in order of names.
+func writeTestInpx(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	name := filepath.Join(t.TempDir(), "test.inpx")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	all := map[string]string{
+		"collection.info": "Test\r\ntest.inpx\r\n1\r\nTest collection\r\n",
+		"version.info":    "20240101\r\n",
+	}
+	for name, content := range files {
+		all[name] = content
+	}
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		content := all[name]
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+func TestBooksLines(t *testing.T) {
+	collection, err := Open("testdata/flibusta_fb2_local.inpx")
+	if err != nil {
+		t.Fatalf(`collection is not opened: %v`, err)
+	}
+	defer collection.Close()
+
+	lines := make(map[string]int)
+	for book, err := range collection.Books(context.Background()) {
+		assert.Nil(t, err)
+		lines[book.File.Archive]++
+		assert.Equal(t, lines[book.File.Archive], book.Line)
+	}
+
+	assert.Equal(t, 3, len(lines))
+}
+
+func TestBooksStop(t *testing.T) {
+	collection, err := Open("testdata/flibusta_fb2_local.inpx")
+	if err != nil {
+		t.Fatalf(`collection is not opened: %v`, err)
+	}
+	defer collection.Close()
+
+	count := 0
+	for range collection.Books(context.Background()) {
+		count++
+		if count == 5 {
+			break
+		}
+	}
+	assert.Equal(t, 5, count)
+
+	// Iterator can be restarted.
+	count = 0
+	for range collection.Books(context.Background()) {
+		count++
+	}
+	assert.Equal(t, 50, count)
+}
+
+func TestBooksCancel(t *testing.T) {
+	collection, err := Open("testdata/flibusta_fb2_local.inpx")
+	if err != nil {
+		t.Fatalf(`collection is not opened: %v`, err)
+	}
+	defer collection.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	count := 0
+	var lastErr error
+	for book, err := range collection.Books(ctx) {
+		if err != nil {
+			lastErr = err
+			assert.Nil(t, book)
+			continue
+		}
+
+		count++
+		cancel()
+	}
+
+	assert.Equal(t, 1, count)
+	assert.ErrorIs(t, lastErr, context.Canceled)
+}
+
+func TestBooksParseError(t *testing.T) {
+	name := writeTestInpx(t, map[string]string{
+		"a.inp": testRecord("1", "First") + "broken\x04record\x04\r\n" + testRecord("3", "Third"),
+	})
+
+	collection, err := Open(name)
+	if err != nil {
+		t.Fatalf(`collection is not opened: %v`, err)
+	}
+	defer collection.Close()
+
+	var books []*Book
+	var lastErr error
+	for book, err := range collection.Books(context.Background()) {
+		if err != nil {
+			lastErr = err
+			break
+		}
+		books = append(books, book)
+	}
+
+	assert.Equal(t, 1, len(books))
+	assert.Equal(t, "First", books[0].Title)
+
+	var parseErr *ParseError
+	if assert.True(t, errors.As(lastErr, &parseErr)) {
+		assert.Equal(t, "a", parseErr.Archive)
+		assert.Equal(t, 2, parseErr.Line)
+	}
+
+	// Stream reports the same error after draining.
+	count := 0
+	for range collection.Stream() {
+		count++
+	}
+	assert.Equal(t, 1, count)
+	assert.ErrorAs(t, collection.Err(), &parseErr)
+}
+
+func TestBooksLenient(t *testing.T) {
+	name := writeTestInpx(t, map[string]string{
+		"a.inp": testRecord("1", "First") + "broken\x04record\x04\r\n" + testRecord("3", "Third"),
+		"b.inp": "\r\n" + testRecord("4", "Fourth"),
+	})
+
+	collection, err := Open(name, WithLenient())
+	if err != nil {
+		t.Fatalf(`collection is not opened: %v`, err)
+	}
+	defer collection.Close()
+
+	var titles []string
+	var parseErrors []*ParseError
+	for book, err := range collection.Books(context.Background()) {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			parseErrors = append(parseErrors, parseErr)
+			continue
+		}
+
+		assert.Nil(t, err)
+		titles = append(titles, book.Title)
+	}
+
+	assert.ElementsMatch(t, []string{"First", "Third", "Fourth"}, titles)
+	assert.ElementsMatch(t, []*ParseError{
+		{Archive: "a", Line: 2, Raw: "broken|record|", Reason: "fields count doesn't match with a structure. expected 14, got 2"},
+		{Archive: "b", Line: 1, Raw: "", Reason: "fields count doesn't match with a structure. expected 14, got 0"},
+	}, parseErrors)
+
+	count := 0
+	for range collection.Stream() {
+		count++
+	}
+	assert.Equal(t, 3, count)
+	assert.Nil(t, collection.Err())
+	assert.Equal(t, 2, len(collection.ParseErrors()))
+}
+
+func TestOpenReader(t *testing.T) {
+	data, err := os.ReadFile("testdata/flibusta_fb2_local.inpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collection, err := OpenReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf(`collection is not opened: %v`, err)
+	}
+	defer collection.Close()
+
+	assert.Equal(t, "Flibusta FB2 Local", collection.Name)
+	books, errs := collectBooks(t, "testdata/flibusta_fb2_local.inpx")
+	assert.Empty(t, errs)
+
+	count := 0
+	for book, err := range collection.Books(context.Background()) {
+		assert.Nil(t, err)
+		assert.Equal(t, books[count], book)
+		count++
+	}
+	assert.Equal(t, 50, count)
+
+	_, err = OpenReader(bytes.NewReader([]byte("not a zip")), 9)
+	assert.NotNil(t, err)
+}
+
+func TestOpenFS(t *testing.T) {
+	data, err := os.ReadFile("testdata/flibusta_fb2_local.inpx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, fsys := range map[string]fs.FS{
+		"dir": os.DirFS("testdata"),
+		"map": fstest.MapFS{"flibusta_fb2_local.inpx": {Data: data}},
+		// Files of this FS don't implement io.ReaderAt.
+		"stream": streamFS{fstest.MapFS{"flibusta_fb2_local.inpx": {Data: data}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			collection, err := OpenFS(fsys, "flibusta_fb2_local.inpx")
+			if err != nil {
+				t.Fatalf(`collection is not opened: %v`, err)
+			}
+			defer collection.Close()
+
+			assert.Equal(t, "20220601", collection.Version)
+			count := 0
+			for _, err := range collection.Books(context.Background()) {
+				assert.Nil(t, err)
+				count++
+			}
+			assert.Equal(t, 50, count)
+		})
+	}
+
+	_, err = OpenFS(os.DirFS("testdata"), "missing.inpx")
+	assert.NotNil(t, err)
+}
+
+type streamFS struct {
+	fsys fs.FS
+}
+
+func (s streamFS) Open(name string) (fs.File, error) {
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return streamFile{f}, nil
+}
+
+type streamFile struct {
+	fs.File
+}
@@ -0,0 +1,30 @@
+package inpx
+
+import "golang.org/x/text/encoding"
+
+// Option configures Parser.
+type Option func(p *Parser)
+
+// WithLenient makes parser skip malformed records instead of stopping at the first one.
+// Books yields *ParseError for every skipped record and continues, Stream collects them into ParseErrors.
+func WithLenient() Option {
+	return func(p *Parser) {
+		p.lenient = true
+	}
+}
+
+// WithWorkers makes parser decode up to n .inp files concurrently.
+// Records are still emitted in archive order, at most n decoded files are kept in memory.
+func WithWorkers(n int) Option {
+	return func(p *Parser) {
+		p.workers = n
+	}
+}
+
+// WithEncoding sets encoding of text in collection. By default, text which isn't valid UTF-8
+// is decoded as Windows-1251 line by line.
+func WithEncoding(enc encoding.Encoding) Option {
+	return func(p *Parser) {
+		p.encoding = enc
+	}
+}
@@ -0,0 +1,74 @@
+package inpx
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+)
+
+// parsedRecord is a result of decoding single line.
+type parsedRecord struct {
+	book *Book
+	err  error
+}
+
+// parseParallel decodes files by p.workers goroutines and yields records in archive order.
+// Every file is decoded completely before its records are yielded, at most p.workers files are
+// decoded ahead of consumer.
+func (p *Parser) parseParallel(ctx context.Context, yield func(*Book, error) bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	files := p.inpFiles()
+	results := make([]chan []parsedRecord, len(files))
+	for i := range results {
+		results[i] = make(chan []parsedRecord, 1)
+	}
+
+	sem := make(chan struct{}, p.workers)
+	go func() {
+		for i, f := range files {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			go func(f *zip.File, res chan<- []parsedRecord) {
+				res <- p.decodeFile(ctx, f)
+			}(f, results[i])
+		}
+	}()
+
+	for i := range files {
+		var records []parsedRecord
+		select {
+		case records = <-results[i]:
+			<-sem
+		case <-ctx.Done():
+			yield(nil, ctx.Err())
+			return
+		}
+
+		for _, r := range records {
+			if !yield(r.book, r.err) {
+				return
+			}
+
+			var parseErr *ParseError
+			if r.err != nil && !(p.lenient && errors.As(r.err, &parseErr)) {
+				return
+			}
+		}
+	}
+}
+
+// decodeFile returns all records of file including errors.
+func (p *Parser) decodeFile(ctx context.Context, f *zip.File) []parsedRecord {
+	var records []parsedRecord
+	p.parseFile(ctx, f, func(book *Book, err error) bool {
+		records = append(records, parsedRecord{book: book, err: err})
+		return true
+	})
+	return records
+}
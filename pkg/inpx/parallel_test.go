@@ -0,0 +1,102 @@
+package inpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collectBooks(t *testing.T, name string, opts ...Option) ([]*Book, []error) {
+	t.Helper()
+
+	collection, err := Open(name, opts...)
+	if err != nil {
+		t.Fatalf(`collection is not opened: %v`, err)
+	}
+	defer collection.Close()
+
+	var books []*Book
+	var errs []error
+	for book, err := range collection.Books(context.Background()) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		books = append(books, book)
+	}
+
+	return books, errs
+}
+
+func TestParallelOrder(t *testing.T) {
+	for _, name := range []string{
+		"testdata/flibusta_fb2_local.inpx",
+		"testdata/flibusta.all-rev2.0-2022-07-04.inpx",
+		"testdata/flibusta_all_local-2025-02-02.inpx",
+	} {
+		t.Run(name, func(t *testing.T) {
+			expected, errs := collectBooks(t, name)
+			assert.Empty(t, errs)
+
+			for _, workers := range []int{2, 3, 16} {
+				books, errs := collectBooks(t, name, WithWorkers(workers))
+				assert.Empty(t, errs)
+				assert.Equal(t, expected, books)
+			}
+		})
+	}
+}
+
+func TestParallelErrors(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		content := testRecord(fmt.Sprint(i*10+1), "Book") + testRecord(fmt.Sprint(i*10+2), "Book")
+		if i == 3 || i == 7 {
+			content += "broken\x04\r\n"
+		}
+		files[fmt.Sprintf("%02d.inp", i)] = content
+	}
+	name := writeTestInpx(t, files)
+
+	books, errs := collectBooks(t, name, WithWorkers(4), WithLenient())
+	assert.Equal(t, 20, len(books))
+	if assert.Equal(t, 2, len(errs)) {
+		var parseErr *ParseError
+		assert.True(t, errors.As(errs[0], &parseErr))
+		assert.Equal(t, "03", parseErr.Archive)
+		assert.True(t, errors.As(errs[1], &parseErr))
+		assert.Equal(t, "07", parseErr.Archive)
+	}
+
+	// Strict mode stops at first error.
+	books, errs = collectBooks(t, name, WithWorkers(4))
+	assert.Equal(t, 8, len(books))
+	assert.Equal(t, 1, len(errs))
+}
+
+func TestParallelStop(t *testing.T) {
+	collection, err := Open("testdata/flibusta_fb2_local.inpx", WithWorkers(2))
+	if err != nil {
+		t.Fatalf(`collection is not opened: %v`, err)
+	}
+	defer collection.Close()
+
+	count := 0
+	for range collection.Books(context.Background()) {
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	assert.Equal(t, 3, count)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for book, err := range collection.Books(ctx) {
+		assert.Nil(t, book)
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+}
@@ -4,11 +4,19 @@ import (
 	"archive/zip"
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"iter"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/text/encoding"
 )
 
 // Defines the type of particular field in the record.
@@ -93,107 +101,286 @@ type Book struct {
 	Deleted       bool
 	PublishedDate time.Time
 	Language      string
+	// Extra keeps values of fields unknown to parser by their names from structure.info, e.g. PUBLISHER or ISBN.
+	Extra map[string]string
+	// Line is a number of record line in .inp file, starting from 1.
+	Line int
+}
+
+// maxRawSnippet limits length of record kept in ParseError.
+const maxRawSnippet = 256
+
+// ParseError describes record which can't be parsed.
+type ParseError struct {
+	// Archive is a name of .inp file without extension.
+	Archive string
+	Line    int
+	// Raw is a beginning of record with fields separated by `|`.
+	Raw    string
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s.inp:%d: %s", e.Archive, e.Line, e.Reason)
 }
 
 // Parser represents `.inpx` collection.
 type Parser struct {
-	arc       *zip.ReadCloser
+	arc       *zip.Reader
+	closer    io.Closer
 	bookCh    chan *Book
 	structure []field
 	err       error
-	Name      string
-	Id        int
-	Comment   string
-	Version   string
+	lenient   bool
+	workers   int
+	encoding  encoding.Encoding
+	mu        sync.Mutex
+	warnings  []*Warning
+	// fieldNames are names of structure fields, nil for default structure.
+	fieldNames []string
+	// parseErrors are records skipped by Stream in lenient mode.
+	parseErrors []*ParseError
+	Name        string
+	Id          int
+	Comment     string
+	Version     string
+}
+
+// Open reads collection meta info from file and validates it.
+func Open(name string, opts ...Option) (*Parser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("error opening archive: %v", err)
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("error opening archive: %v", err)
+	}
+
+	p, err := newParser(f, stat.Size(), f, opts)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// OpenReader reads collection meta info from r and validates it.
+// r must stay readable until the end of parsing.
+func OpenReader(r io.ReaderAt, size int64, opts ...Option) (*Parser, error) {
+	return newParser(r, size, nil, opts)
+}
+
+// OpenFS reads collection meta info from file of fsys and validates it.
+// File is read into memory if it doesn't implement io.ReaderAt.
+func OpenFS(fsys fs.FS, name string, opts ...Option) (*Parser, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("error opening archive: %v", err)
+	}
+
+	if ra, ok := f.(io.ReaderAt); ok {
+		stat, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("error opening archive: %v", err)
+		}
+
+		p, err := newParser(ra, stat.Size(), f, opts)
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+
+		return p, nil
+	}
+
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("error opening archive: %v", err)
+	}
+
+	return newParser(bytes.NewReader(data), int64(len(data)), nil, opts)
 }
 
-// Open reads collection meta info and validates it.
-func Open(name string) (*Parser, error) {
-	arc, err := zip.OpenReader(name)
+// newParser reads collection meta info. closer is closed by Parser.Close.
+func newParser(r io.ReaderAt, size int64, closer io.Closer, opts []Option) (*Parser, error) {
+	arc, err := zip.NewReader(r, size)
 	if err != nil {
 		return nil, fmt.Errorf("error opening archive: %v", err)
 	}
 
-	r := new(Parser)
-	r.arc = arc
-	r.bookCh = make(chan *Book, 128)
+	p := new(Parser)
+	p.arc = arc
+	p.closer = closer
+	p.bookCh = make(chan *Book, 128)
+	for _, opt := range opts {
+		opt(p)
+	}
 
-	err = r.readCollection()
+	err = p.readCollection()
 	if err != nil {
-		_ = r.arc.Close()
 		return nil, fmt.Errorf("error parsing archive: %v", err)
 	}
 
-	err = r.readVersion()
+	err = p.readVersion()
 	if err != nil {
-		_ = r.arc.Close()
 		return nil, fmt.Errorf("error parsing archive: %v", err)
 	}
 
-	r.structure = r.getStructure()
+	p.structure, p.fieldNames = p.getStructure()
 
-	return r, nil
+	return p, nil
 }
 
 // Close underlying zip archive.
 func (p *Parser) Close() {
-	_ = p.arc.Close()
+	if p.closer != nil {
+		_ = p.closer.Close()
+	}
 }
 
 // Err returns the most recent decoder error if any, or nil.
 func (p *Parser) Err() error { return p.err }
 
+// ParseErrors returns records skipped by Stream in lenient mode.
+func (p *Parser) ParseErrors() []*ParseError { return p.parseErrors }
+
 // Stream begins parsing from the underlying reader and returns a streaming Book channel.
+// Channel must be drained, otherwise parsing goroutine is never finished. Use Books for cancellable parsing.
 func (p *Parser) Stream() chan *Book {
 	go p.parse()
 	return p.bookCh
 }
 
-// parse decodes records from files in archives and emits it to Book channel.
+// parse emits records to Book channel.
 func (p *Parser) parse() {
 	defer close(p.bookCh)
 
-	for _, f := range p.arc.File {
-		if !strings.HasSuffix(f.Name, ".inp") {
+	for book, err := range p.Books(context.Background()) {
+		var parseErr *ParseError
+		if p.lenient && errors.As(err, &parseErr) {
+			p.parseErrors = append(p.parseErrors, parseErr)
 			continue
 		}
 
-		archive := strings.TrimSuffix(f.Name, ".inp")
-		rc, err := f.Open()
 		if err != nil {
 			p.err = err
-			break
+			return
 		}
 
-		br := bufio.NewReader(rc)
-		for {
-			line, err := br.ReadBytes('\n')
-			if len(line) == 0 && err == io.EOF {
-				break
-			}
+		p.bookCh <- book
+	}
+}
 
-			if err != nil && err != io.EOF {
-				rc.Close()
-				p.err = err
+// Books returns iterator over records of all files in archive.
+// Iteration stops at first error, which is yielded with nil Book. Records with wrong structure are reported as *ParseError,
+// in lenient mode iteration continues after them.
+// Cancelling ctx stops iteration with ctx.Err().
+func (p *Parser) Books(ctx context.Context) iter.Seq2[*Book, error] {
+	return func(yield func(*Book, error) bool) {
+		if p.workers > 1 {
+			p.parseParallel(ctx, yield)
+			return
+		}
+
+		for _, f := range p.inpFiles() {
+			if !p.parseFile(ctx, f, yield) {
 				return
 			}
+		}
+	}
+}
+
+func (p *Parser) inpFiles() []*zip.File {
+	var files []*zip.File
+	for _, f := range p.arc.File {
+		if strings.HasSuffix(f.Name, ".inp") {
+			files = append(files, f)
+		}
+	}
+	return files
+}
 
-			line = bytes.TrimSpace(line)
-			values := bytes.Split(line, []byte{0x04})
-			book, err := mapFieldsToBook(p.structure, values[:len(values)-1])
+// parseFile decodes records from single .inp file. Returns false if iteration must be stopped.
+func (p *Parser) parseFile(ctx context.Context, f *zip.File, yield func(*Book, error) bool) bool {
+	archive := strings.TrimSuffix(f.Name, ".inp")
+	rc, err := f.Open()
+	if err != nil {
+		yield(nil, fmt.Errorf("error opening %s: %w", f.Name, err))
+		return false
+	}
+	defer rc.Close()
+
+	br := bufio.NewReader(rc)
+	decoder := &textDecoder{enc: p.encoding}
+	lineNo := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			yield(nil, err)
+			return false
+		}
+
+		line, err := br.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			return true
+		}
+
+		if err != nil && err != io.EOF {
+			yield(nil, fmt.Errorf("error reading %s: %w", f.Name, err))
+			return false
+		}
+
+		// Records can be separated by bare CR as well.
+		for _, record := range bytes.Split(bytes.TrimRight(line, "\r\n"), []byte{'\r'}) {
+			lineNo++
+			record, warning := decoder.decode(record)
+			if warning != "" {
+				p.addWarning(archive, lineNo, warning)
+			}
+
+			book, err := p.parseLine(record)
 			if err != nil {
-				rc.Close()
-				p.err = err
-				return
+				if !yield(nil, newParseError(archive, lineNo, record, err)) || !p.lenient {
+					return false
+				}
+				continue
 			}
 
 			book.File.Archive = archive
-			p.bookCh <- book
+			book.Line = lineNo
+			if !yield(book, nil) {
+				return false
+			}
 		}
-		rc.Close()
 	}
 }
 
+func newParseError(archive string, line int, raw []byte, err error) *ParseError {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > maxRawSnippet {
+		raw = raw[:maxRawSnippet]
+	}
+
+	return &ParseError{
+		Archive: archive,
+		Line:    line,
+		Raw:     strings.ToValidUTF8(strings.ReplaceAll(string(raw), "\x04", "|"), "\uFFFD"),
+		Reason:  err.Error(),
+	}
+}
+
+// parseLine decodes single record.
+func (p *Parser) parseLine(line []byte) (*Book, error) {
+	line = bytes.TrimSpace(line)
+	values := bytes.Split(line, []byte{0x04})
+	return mapFieldsToBook(p.structure, p.fieldNames, values[:len(values)-1])
+}
+
 // Splits string separated by `:` and cleans from empty trailing element.
 func splitColonStr(str string) []string {
 	values := strings.Split(str, ":")
@@ -222,10 +409,10 @@ func getAuthorFromStr(s string) Author {
 	}
 }
 
-// Constructs Book from array of fields.
-func mapFieldsToBook(structure []field, values [][]byte) (*Book, error) {
+// Constructs Book from array of fields. Values of unknown fields are kept in Extra by their names.
+func mapFieldsToBook(structure []field, names []string, values [][]byte) (*Book, error) {
 	if len(structure) != len(values) {
-		return nil, fmt.Errorf("fields count doesn't math with a structure. expected %d, got %d", len(structure), len(values))
+		return nil, fmt.Errorf("fields count doesn't match with a structure. expected %d, got %d", len(structure), len(values))
 	}
 
 	book := new(Book)
@@ -279,6 +466,14 @@ func mapFieldsToBook(structure []field, values [][]byte) (*Book, error) {
 			book.File.Ext = value
 		case folder:
 			book.File.Folder = value
+		case unknown:
+			if names == nil || names[i] == "" || value == "" {
+				continue
+			}
+			if book.Extra == nil {
+				book.Extra = make(map[string]string)
+			}
+			book.Extra[names[i]] = value
 		}
 	}
 	return book, nil
@@ -297,6 +492,7 @@ func (p *Parser) readVersion() error {
 	if err != nil {
 		return err
 	}
+	p.Version = p.decodeString(p.Version)
 
 	return nil
 }
@@ -314,6 +510,7 @@ func (p *Parser) readCollection() error {
 	if err != nil {
 		return err
 	}
+	p.Name = p.decodeString(p.Name)
 
 	// filename
 	_, _ = br.ReadString('\n')
@@ -326,31 +523,35 @@ func (p *Parser) readCollection() error {
 	// FIXME: there is can be more than one line, but who cares?
 	_, _ = br.ReadString('\n')
 	p.Comment, _ = readCleanString(br)
+	p.Comment = p.decodeString(p.Comment)
 
 	return nil
 }
 
-// Parses structure from archive and returns it.
-func (p *Parser) getStructure() []field {
+// Parses structure from archive and returns it with names of fields.
+func (p *Parser) getStructure() ([]field, []string) {
 	r, err := p.getFileByName("structure.info")
 	if err != nil {
-		return defaultStructure
+		return defaultStructure, nil
 	}
 	defer r.Close()
 
 	br := bufio.NewReader(r)
 	ssv, err := readCleanString(br)
 	if err != nil {
-		return defaultStructure
+		return defaultStructure, nil
 	}
 
 	structure := make([]field, 0)
+	names := make([]string, 0)
 	fieldNames := strings.Split(ssv, ";")
 	for _, fieldName := range fieldNames[:len(fieldNames)-1] {
-		structure = append(structure, parseFieldName(fieldName))
+		name := strings.ToUpper(strings.TrimSpace(fieldName))
+		structure = append(structure, parseFieldName(name))
+		names = append(names, name)
 	}
 
-	return structure
+	return structure, names
 }
 
 // Opens file from archive by file name.
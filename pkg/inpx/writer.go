@@ -0,0 +1,209 @@
+package inpx
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writerStructure is a structure of records written by Writer.
+var writerStructure = []field{
+	author, genre, title, series, seriesNo, fileName, fileSize, libId, deleted, ext, publishedDate, language, keywords, folder,
+}
+
+// fieldSanitizer removes separators which can't be escaped in records.
+var fieldSanitizer = strings.NewReplacer("\x04", " ", "\r", " ", "\n", " ")
+
+// listSanitizer additionally removes separators of lists.
+var listSanitizer = strings.NewReplacer("\x04", " ", "\r", " ", "\n", " ", ":", " ", ",", " ")
+
+// Writer creates `.inpx` collection. Records are buffered in memory per archive
+// and written to the underlying writer by Close.
+type Writer struct {
+	w        io.Writer
+	archives map[string]*bytes.Buffer
+	// order is an order of archives by the first record.
+	order []string
+	// fields and names are structure of records, it's built once ExtraFields are known.
+	fields []field
+	names  []string
+
+	Name string
+	// FileName is a name of collection file stored in collection.info.
+	FileName string
+	Id       int
+	Comment  string
+	// Version defaults to the current date.
+	Version string
+	// ExtraFields are names of fields written from Book.Extra after the standard ones.
+	// They must be set before the first record is added.
+	ExtraFields []string
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:        w,
+		archives: make(map[string]*bytes.Buffer),
+	}
+}
+
+// Add appends record to .inp file named by book.File.Archive.
+func (w *Writer) Add(book *Book) error {
+	if book.File.Archive == "" {
+		return fmt.Errorf("archive of book %d is not set", book.LibId)
+	}
+
+	buf, ok := w.archives[book.File.Archive]
+	if !ok {
+		buf = new(bytes.Buffer)
+		w.archives[book.File.Archive] = buf
+		w.order = append(w.order, book.File.Archive)
+	}
+
+	structure, names := w.structure()
+	writeRecord(buf, structure, names, book)
+	return nil
+}
+
+// structure returns fields of records with their names. It's built on the first call.
+func (w *Writer) structure() ([]field, []string) {
+	if w.fields != nil {
+		return w.fields, w.names
+	}
+
+	w.fields = slices.Clone(writerStructure)
+	for _, f := range writerStructure {
+		w.names = append(w.names, fieldName(f))
+	}
+	for _, name := range w.ExtraFields {
+		w.fields = append(w.fields, unknown)
+		w.names = append(w.names, strings.ToUpper(name))
+	}
+	return w.fields, w.names
+}
+
+// Close writes collection. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	version := w.Version
+	if version == "" {
+		version = time.Now().Format("20060102")
+	}
+
+	_, names := w.structure()
+
+	zw := zip.NewWriter(w.w)
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"collection.info", fmt.Sprintf("%s\r\n%s\r\n%d\r\n%s\r\n", clean(w.Name), clean(w.FileName), w.Id, clean(w.Comment))},
+		{"version.info", version + "\r\n"},
+		{"structure.info", strings.Join(names, ";") + ";\r\n"},
+	}
+	for _, f := range files {
+		if err := writeZipFile(zw, f.name, []byte(f.content)); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range w.order {
+		if err := writeZipFile(zw, name+".inp", w.archives[name].Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeZipFile(zw *zip.Writer, name string, content []byte) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fw.Write(content)
+	return err
+}
+
+// writeRecord writes book fields in order of structure, unknown fields are taken from Extra by names.
+func writeRecord(buf *bytes.Buffer, structure []field, names []string, book *Book) {
+	for i, f := range structure {
+		switch f {
+		case author:
+			for _, a := range book.Authors {
+				buf.WriteString(cleanList(a.LastName))
+				buf.WriteByte(',')
+				buf.WriteString(cleanList(a.FirstName))
+				buf.WriteByte(',')
+				buf.WriteString(cleanList(a.MiddleName))
+				buf.WriteByte(':')
+			}
+		case genre:
+			for _, g := range book.Genres {
+				buf.WriteString(cleanList(g))
+				buf.WriteByte(':')
+			}
+		case title:
+			buf.WriteString(clean(book.Title))
+		case series:
+			buf.WriteString(clean(book.Series))
+		case seriesNo:
+			if book.SeriesNo > 0 {
+				buf.WriteString(strconv.Itoa(book.SeriesNo))
+			}
+		case fileName:
+			buf.WriteString(clean(book.File.Name))
+		case fileSize:
+			buf.WriteString(strconv.Itoa(book.File.Size))
+		case libId:
+			buf.WriteString(strconv.Itoa(book.LibId))
+		case deleted:
+			if book.Deleted {
+				buf.WriteByte('1')
+			} else {
+				buf.WriteByte('0')
+			}
+		case ext:
+			buf.WriteString(clean(book.File.Ext))
+		case publishedDate:
+			if !book.PublishedDate.IsZero() {
+				buf.WriteString(book.PublishedDate.Format("2006-01-02"))
+			}
+		case language:
+			buf.WriteString(clean(book.Language))
+		case folder:
+			buf.WriteString(clean(book.File.Folder))
+		case unknown:
+			buf.WriteString(clean(book.Extra[names[i]]))
+		}
+		buf.WriteByte(0x04)
+	}
+	buf.WriteString("\r\n")
+}
+
+func clean(s string) string {
+	return strings.TrimSpace(fieldSanitizer.Replace(s))
+}
+
+func cleanList(s string) string {
+	return strings.TrimSpace(listSanitizer.Replace(s))
+}
+
+// fieldName returns string representation of field.
+func fieldName(f field) string {
+	for name, v := range fieldsMap {
+		if v == f {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+package inpx
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriterRoundTrip(t *testing.T) {
+	books := []*Book{
+		{
+			Authors:       []Author{{LastName: "Толстой", FirstName: "Лев", MiddleName: "Николаевич"}, {LastName: "Smith, Jr:"}},
+			Genres:        []string{"prose_classic", "prose_history"},
+			Title:         "Война и мир\r\n",
+			Series:        "Эпопея",
+			SeriesNo:      1,
+			File:          File{Name: "100", Size: 1024, Ext: "fb2", Archive: "books-1"},
+			LibId:         100,
+			PublishedDate: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+			Language:      "ru",
+		},
+		{
+			Title:   "Loose\x04book.epub",
+			File:    File{Name: "Loose book.epub", Size: 10, Ext: "epub", Folder: "misc/new", Archive: "misc_new"},
+			LibId:   101,
+			Deleted: true,
+		},
+		{
+			Title: "Second",
+			File:  File{Name: "102", Size: 20, Ext: "fb2", Archive: "books-1"},
+			LibId: 102,
+		},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Name = "Test"
+	w.FileName = "test.inpx"
+	w.Id = 7
+	w.Comment = "Generated\ncollection"
+	w.Version = "20240101"
+	for _, b := range books {
+		assert.NoError(t, w.Add(b))
+	}
+	assert.Error(t, w.Add(&Book{LibId: 1}))
+	assert.NoError(t, w.Close())
+
+	p, err := OpenReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer p.Close()
+
+	assert.Equal(t, "Test", p.Name)
+	assert.Equal(t, 7, p.Id)
+	assert.Equal(t, "Generated collection", p.Comment)
+	assert.Equal(t, "20240101", p.Version)
+
+	var got []*Book
+	for b, err := range p.Books(context.Background()) {
+		if !assert.NoError(t, err) {
+			return
+		}
+		got = append(got, b)
+	}
+	if !assert.Len(t, got, 3) {
+		return
+	}
+
+	assert.Equal(t, []Author{{"Толстой", "Лев", "Николаевич"}, {"Smith  Jr", "", ""}}, got[0].Authors)
+	assert.Equal(t, []string{"prose_classic", "prose_history"}, got[0].Genres)
+	assert.Equal(t, "Война и мир", got[0].Title)
+	assert.Equal(t, "Эпопея", got[0].Series)
+	assert.Equal(t, 1, got[0].SeriesNo)
+	assert.Equal(t, File{Name: "100", Size: 1024, Ext: "fb2", Archive: "books-1"}, got[0].File)
+	assert.Equal(t, books[0].PublishedDate, got[0].PublishedDate)
+	assert.Equal(t, "ru", got[0].Language)
+
+	assert.Equal(t, 102, got[1].LibId)
+	assert.Equal(t, "books-1", got[1].File.Archive)
+
+	assert.Equal(t, "Loose book.epub", got[2].Title)
+	assert.Equal(t, File{Name: "Loose book.epub", Size: 10, Ext: "epub", Folder: "misc/new", Archive: "misc_new"}, got[2].File)
+	assert.True(t, got[2].Deleted)
+	assert.Nil(t, got[2].Authors)
+}
+
+func TestWriterExtraFields(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.ExtraFields = []string{"isbn", "PUBLISHER"}
+	assert.NoError(t, w.Add(&Book{
+		Title: "With extra",
+		File:  File{Name: "1", Ext: "fb2", Archive: "a"},
+		LibId: 1,
+		Extra: map[string]string{"ISBN": "978-5-17-000000-0", "PUBLISHER": "АСТ;\nМосква", "IGNORED": "value"},
+	}))
+	assert.NoError(t, w.Add(&Book{Title: "Without extra", File: File{Name: "2", Ext: "fb2", Archive: "a"}, LibId: 2}))
+	assert.NoError(t, w.Close())
+
+	p, err := OpenReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer p.Close()
+
+	var got []*Book
+	for b, err := range p.Books(context.Background()) {
+		if !assert.NoError(t, err) {
+			return
+		}
+		got = append(got, b)
+	}
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, map[string]string{"ISBN": "978-5-17-000000-0", "PUBLISHER": "АСТ; Москва"}, got[0].Extra)
+		assert.Nil(t, got[1].Extra)
+	}
+}
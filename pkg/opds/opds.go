@@ -17,14 +17,15 @@ const (
 )
 
 const (
-	LinkRelSelf    = "self"
-	LinkRelStart   = "start"
-	LinkRelFirst   = "first"
-	LinkRelLast    = "last"
-	LinkRelNext    = "next"
-	LinkRelPrev    = "previous"
-	LinkRelSearch  = "search"
-	LinkRelRelated = "related"
+	LinkRelSelf       = "self"
+	LinkRelStart      = "start"
+	LinkRelFirst      = "first"
+	LinkRelLast       = "last"
+	LinkRelNext       = "next"
+	LinkRelPrev       = "previous"
+	LinkRelSearch     = "search"
+	LinkRelRelated    = "related"
+	LinkRelSubsection = "subsection"
 
 	LinkRelAcquisition = "http://opds-spec.org/acquisition"
 	LinkRelImage       = "http://opds-spec.org/image"